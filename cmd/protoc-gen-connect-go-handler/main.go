@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io"
 	"os"
@@ -13,6 +14,15 @@ import (
 const maxInputSize = 32 << 20 // 32 MiB
 
 func main() {
+	// protoc and buf run plugins without arguments
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "protoc-gen-connect-go-handler: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := run(); err != nil {
 		fmt.Fprintf(os.Stderr, "protoc-gen-connect-go-handler: %v\n", err)
 		os.Exit(1)
@@ -57,3 +67,33 @@ func run() error {
 
 	return nil
 }
+
+// runMigrate converts handler packages between the per_service and
+// per_method layouts
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: protoc-gen-connect-go-handler migrate -mode per_service|per_method <handler dir>...\n")
+		flags.PrintDefaults()
+	}
+	mode := flags.String("mode", "", "layout to migrate to: per_service or per_method")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *mode == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("migrate needs -mode and at least one handler directory")
+	}
+
+	for _, dir := range flags.Args() {
+		changes, err := generator.Migrate(dir, *mode)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+	}
+	fmt.Printf("Set mode=%s in the plugin options before generating again.\n", *mode)
+	return nil
+}
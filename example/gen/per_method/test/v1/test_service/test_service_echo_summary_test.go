@@ -0,0 +1,44 @@
+package test_v1
+
+import (
+	"context"
+	"testing"
+
+	"connectrpc.com/connect"
+	testv1 "example.com/test/gen/proto/test/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTestServiceHandler_EchoSummary(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      *testv1.EchoSummaryRequest
+		want     *testv1.EchoSummaryResponse
+		wantCode connect.Code // expected error code, 0 if the call succeeds
+	}{
+		{
+			name:     "not implemented",
+			req:      &testv1.EchoSummaryRequest{},
+			wantCode: connect.CodeUnimplemented,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewTestServiceHandler()
+			resp, err := h.EchoSummary(context.Background(), connect.NewRequest(tt.req))
+			if tt.wantCode != 0 {
+				if connect.CodeOf(err) != tt.wantCode {
+					t.Fatalf("EchoSummary() error = %v, want code %v", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EchoSummary() failed: %v", err)
+			}
+			if !proto.Equal(resp.Msg, tt.want) {
+				t.Errorf("EchoSummary() = %v, want %v", resp.Msg, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package test_v1
+
+import (
+	"context"
+	"testing"
+
+	"connectrpc.com/connect"
+	testv1 "example.com/test/gen/proto/test/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTestServiceHandler_Echo(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      *testv1.EchoRequest
+		want     *testv1.EchoResponse
+		wantCode connect.Code // expected error code, 0 if the call succeeds
+	}{
+		{
+			name:     "not implemented",
+			req:      &testv1.EchoRequest{},
+			wantCode: connect.CodeUnimplemented,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewTestServiceHandler()
+			resp, err := h.Echo(context.Background(), connect.NewRequest(tt.req))
+			if tt.wantCode != 0 {
+				if connect.CodeOf(err) != tt.wantCode {
+					t.Fatalf("Echo() error = %v, want code %v", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Echo() failed: %v", err)
+			}
+			if !proto.Equal(resp.Msg, tt.want) {
+				t.Errorf("Echo() = %v, want %v", resp.Msg, tt.want)
+			}
+		})
+	}
+}
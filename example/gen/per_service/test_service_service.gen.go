@@ -4,16 +4,27 @@ package test_v1
 
 import (
 	"context"
-	
+
 	"connectrpc.com/connect"
-	"example.com/test/gen/proto/test/v1"
+	testv1 "example.com/test/gen/proto/test/v1"
 )
 
 // Ensure TestServiceHandler implements the handler interface
 var _ TestServiceServer = (*TestServiceHandler)(nil)
 
 // TestServiceServer defines the interface for TestService service
+//
+// TestService provides test functionality.
+//
+// Proto: test.v1.TestService (test/v1/test_service.proto:8)
 type TestServiceServer interface {
+	// Echo returns the input message
+	//
+	// Proto: test.v1.TestService.Echo (test/v1/test_service.proto:10)
 	Echo(context.Context, *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoResponse], error)
+
+	// EchoSummary aggregates previous echoes
+	//
+	// Proto: test.v1.TestService.EchoSummary (test/v1/test_service.proto:13)
 	EchoSummary(context.Context, *connect.Request[testv1.EchoSummaryRequest]) (*connect.Response[testv1.EchoSummaryResponse], error)
 }
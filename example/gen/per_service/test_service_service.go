@@ -1,4 +1,5 @@
 package test_v1
+
 import (
 	"context"
 	"errors"
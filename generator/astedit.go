@@ -0,0 +1,308 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// astEdit changes the syntax tree of a Go file and prints the result with
+// go/printer. Code added to the file is parsed on its own and its nodes and
+// comments are moved into the tree; when printing, every node is positioned
+// as if the file had been written that way, so the printer keeps each
+// comment next to the code it was written next to.
+type astEdit struct {
+	fset    *token.FileSet
+	file    *ast.File
+	tokFile *token.File
+	src     string
+	inserts []insertion
+	removed []byteRange
+}
+
+// insertion is source text added to the file, parsed into the nodes it adds
+type insertion struct {
+	offset   int         // offset in the original source the text goes before
+	text     string      // the text as it appears in the edited file
+	tokFile  *token.File // the file text was parsed in
+	prefix   int         // bytes parsed ahead of text
+	comments []*ast.CommentGroup
+}
+
+// byteRange is a range of the original source removed with its nodes
+type byteRange struct {
+	start, end int
+}
+
+// newASTEdit parses src for editing
+func newASTEdit(src string) (*astEdit, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	return &astEdit{fset: fset, file: file, tokFile: fset.File(file.Pos()), src: src}, nil
+}
+
+// parsed returns the original source with its positions
+func (e *astEdit) parsed() parsedSource {
+	return parsedSource{src: e.src, tokFile: e.tokFile}
+}
+
+// parse parses text, to be inserted at offset, wrapped in prefix and suffix
+func (e *astEdit) parse(offset int, prefix, text, suffix string) (*ast.File, error) {
+	file, err := parser.ParseFile(e.fset, "", prefix+text+suffix, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	e.inserts = append(e.inserts, insertion{
+		offset:   offset,
+		text:     text,
+		tokFile:  e.fset.File(file.Pos()),
+		prefix:   len(prefix),
+		comments: file.Comments,
+	})
+	return file, nil
+}
+
+// insertDecls inserts the top-level declarations in text at offset and
+// returns them. Insertions at the same offset keep their order.
+func (e *astEdit) insertDecls(offset int, text string) ([]ast.Decl, error) {
+	file, err := e.parse(offset, "package p\n\n", text, "")
+	if err != nil {
+		return nil, err
+	}
+	e.file.Decls = append(e.file.Decls, file.Decls...)
+	return file.Decls, nil
+}
+
+// addImports adds imports to the file, joining the group of its import
+// block that holds imports of their kind
+func (e *astEdit) addImports(imports []Import) error {
+	decl := importBlock(e.file)
+	for _, edit := range importEdits(e.tokFile, e.file, e.src, imports) {
+		if decl == nil {
+			if _, err := e.insertDecls(edit.offset, edit.text); err != nil {
+				return fmt.Errorf("failed to add imports: %w", err)
+			}
+			continue
+		}
+		file, err := e.parse(edit.offset, "package p\n\nimport (\n", edit.text, ")\n")
+		if err != nil {
+			return fmt.Errorf("failed to add imports: %w", err)
+		}
+		for _, spec := range file.Imports {
+			decl.Specs = append(decl.Specs, spec)
+		}
+	}
+	return nil
+}
+
+// replaceDecl replaces a declaration and its doc comment with the
+// declarations in text. Comments after it on its last line are kept.
+func (e *astEdit) replaceDecl(decl ast.Decl, text string) error {
+	start := decl.Pos()
+	if doc := declDoc(decl); doc != nil {
+		start = doc.Pos()
+	}
+	e.remove(decl, e.tokFile.Offset(start), e.tokFile.Offset(decl.End()))
+	_, err := e.insertDecls(e.tokFile.Offset(start), text)
+	return err
+}
+
+// removeDecl removes a declaration along with the comments belonging to it,
+// see declExtent
+func (e *astEdit) removeDecl(decl ast.Decl) {
+	start, end := declExtent(e.parsed(), decl)
+	e.remove(decl, start, end)
+}
+
+// remove drops decl and the comments in the source range holding it
+func (e *astEdit) remove(decl ast.Decl, start, end int) {
+	for i, d := range e.file.Decls {
+		if d == decl {
+			e.file.Decls = append(e.file.Decls[:i:i], e.file.Decls[i+1:]...)
+			break
+		}
+	}
+
+	var comments []*ast.CommentGroup
+	for _, group := range e.file.Comments {
+		if e.tokFile.Offset(group.Pos()) < start || e.tokFile.Offset(group.End()) > end {
+			comments = append(comments, group)
+		}
+	}
+	e.file.Comments = comments
+	e.removed = append(e.removed, byteRange{start, end})
+}
+
+// print returns the edited file, formatted like gofmt
+func (e *astEdit) print() (string, error) {
+	sort.SliceStable(e.inserts, func(i, j int) bool {
+		return e.inserts[i].offset < e.inserts[j].offset
+	})
+	sort.Slice(e.removed, func(i, j int) bool {
+		return e.removed[i].start < e.removed[j].start
+	})
+
+	// shift returns the offset in the edited file of an offset of the source
+	// that is not removed, counting the first n insertions
+	shift := func(offset, n int) int {
+		shifted := offset
+		for _, ins := range e.inserts[:n] {
+			if ins.offset <= offset {
+				shifted += len(ins.text)
+			}
+		}
+		for _, r := range e.removed {
+			if r.end <= offset {
+				shifted -= r.end - r.start
+			}
+		}
+		return shifted
+	}
+
+	// Lay the edited file out to find where its lines start
+	lines := []int{0}
+	size := 0
+	write := func(text string) {
+		for i := 0; i < len(text); i++ {
+			if text[i] == '\n' {
+				lines = append(lines, size+i+1)
+			}
+		}
+		size += len(text)
+	}
+	last, next := 0, 0
+	skipRemoved := func(offset int) {
+		for ; next < len(e.removed) && e.removed[next].start < offset; next++ {
+			write(e.src[last:e.removed[next].start])
+			last = e.removed[next].end
+		}
+	}
+	for _, ins := range e.inserts {
+		skipRemoved(ins.offset)
+		write(e.src[last:ins.offset])
+		write(ins.text)
+		last = ins.offset
+	}
+	skipRemoved(len(e.src) + 1)
+	write(e.src[last:])
+	if lines[len(lines)-1] >= size {
+		lines = lines[:len(lines)-1]
+	}
+
+	edited := e.fset.AddFile("", -1, size)
+	edited.SetLines(lines)
+
+	bases := make(map[*token.File]int, len(e.inserts))
+	prefixes := make(map[*token.File]int, len(e.inserts))
+	for i, ins := range e.inserts {
+		bases[ins.tokFile] = shift(ins.offset, i)
+		prefixes[ins.tokFile] = ins.prefix
+	}
+	remap := func(pos token.Pos) token.Pos {
+		if !pos.IsValid() {
+			return pos
+		}
+		f := e.fset.File(pos)
+		if f == e.tokFile {
+			return edited.Pos(shift(f.Offset(pos), len(e.inserts)))
+		}
+		if base, ok := bases[f]; ok {
+			return edited.Pos(base + f.Offset(pos) - prefixes[f])
+		}
+		return pos
+	}
+
+	for _, ins := range e.inserts {
+		e.file.Comments = append(e.file.Comments, ins.comments...)
+	}
+	remapPositions(e.file, remap)
+
+	// Put the inserted nodes in source order
+	sort.SliceStable(e.file.Decls, func(i, j int) bool {
+		return e.file.Decls[i].Pos() < e.file.Decls[j].Pos()
+	})
+	sort.SliceStable(e.file.Comments, func(i, j int) bool {
+		return e.file.Comments[i].Pos() < e.file.Comments[j].Pos()
+	})
+	e.file.Imports = nil
+	for _, decl := range e.file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+		sort.SliceStable(gen.Specs, func(i, j int) bool {
+			return gen.Specs[i].Pos() < gen.Specs[j].Pos()
+		})
+		for _, spec := range gen.Specs {
+			e.file.Imports = append(e.file.Imports, spec.(*ast.ImportSpec))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, e.fset, e.file); err != nil {
+		return "", fmt.Errorf("failed to print edited file: %w", err)
+	}
+	return buf.String(), nil
+}
+
+var (
+	posType   = reflect.TypeOf(token.NoPos)
+	objType   = reflect.TypeOf((*ast.Object)(nil))
+	scopeType = reflect.TypeOf((*ast.Scope)(nil))
+)
+
+// remapPositions replaces every position in the syntax tree rooted at node,
+// visiting nodes shared by several fields once
+func remapPositions(node ast.Node, remap func(token.Pos) token.Pos) {
+	seen := make(map[uintptr]bool)
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Pointer:
+			if v.IsNil() || v.Type() == objType || v.Type() == scopeType || seen[v.Pointer()] {
+				return
+			}
+			seen[v.Pointer()] = true
+			walk(v.Elem())
+		case reflect.Interface:
+			if !v.IsNil() {
+				walk(v.Elem())
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i))
+			}
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				field := v.Field(i)
+				if field.Type() == posType {
+					if field.CanSet() {
+						field.SetInt(int64(remap(token.Pos(field.Int()))))
+					}
+					continue
+				}
+				walk(field)
+			}
+		}
+	}
+	walk(reflect.ValueOf(node))
+}
+
+// lineEnd returns the offset of the newline ending the line of pos, or the
+// length of the source if that line is the last
+func (p parsedSource) lineEnd(pos token.Pos) int {
+	offset := p.offset(pos)
+	if nl := strings.IndexByte(p.src[offset:], '\n'); nl != -1 {
+		return offset + nl
+	}
+	return len(p.src)
+}
@@ -2,12 +2,55 @@ package generator
 
 import (
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"go/ast"
 	"go/parser"
 	"go/token"
 )
 
+// detectPackageName returns the package clause shared by the non-test Go
+// files in dir, or "" if dir holds no parsable Go files. When files disagree
+// the most common name wins.
+func detectPackageName(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	counts := make(map[string]int)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+
+		file, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, name), nil, parser.PackageClauseOnly)
+		if err != nil {
+			continue
+		}
+		counts[file.Name.Name]++
+	}
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
 // FuncExists checks if a method with the given name exists for the specified struct
 func FuncExists(filePath, structName, methodName string) bool {
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
@@ -20,30 +63,71 @@ func FuncExists(filePath, structName, methodName string) bool {
 		return false
 	}
 
+	_, exists := methodDecls(file, structName)[methodName]
+	return exists
+}
+
+// methodDecls returns the methods declared in file for the specified struct, by name
+func methodDecls(file *ast.File, structName string) map[string]*ast.FuncDecl {
+	methods := make(map[string]*ast.FuncDecl)
 	for _, decl := range file.Decls {
-		if funcDecl, ok := decl.(*ast.FuncDecl); ok {
-			// Check if this is a method (has a receiver)
-			if funcDecl.Recv != nil && len(funcDecl.Recv.List) > 0 {
-				// Check receiver type
-				if recv := funcDecl.Recv.List[0]; recv.Type != nil {
-					var receiverType string
-					switch t := recv.Type.(type) {
-					case *ast.StarExpr:
-						if ident, ok := t.X.(*ast.Ident); ok {
-							receiverType = ident.Name
-						}
-					case *ast.Ident:
-						receiverType = t.Name
-					}
-
-					// Check if this method belongs to our struct and has the right name
-					if receiverType == structName && funcDecl.Name.Name == methodName {
-						return true
-					}
-				}
-			}
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && receiverTypeName(funcDecl) == structName {
+			methods[funcDecl.Name.Name] = funcDecl
 		}
 	}
+	return methods
+}
 
+// receiverTypeName returns the receiver type name of a method, or "" for functions
+func receiverTypeName(funcDecl *ast.FuncDecl) string {
+	// Check if this is a method (has a receiver)
+	if funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 {
+		return ""
+	}
+
+	// Check receiver type
+	switch t := funcDecl.Recv.List[0].Type.(type) {
+	case *ast.StarExpr:
+		if ident, ok := t.X.(*ast.Ident); ok {
+			return ident.Name
+		}
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+// connectParamTypes are the connect-go types handler methods receive
+var connectParamTypes = map[string]bool{
+	"Request":      true,
+	"ClientStream": true,
+	"ServerStream": true,
+	"BidiStream":   true,
+}
+
+// isHandlerSignature reports whether funcDecl looks like a connect handler
+// method: exported and taking a pointer to a connect request or stream type
+func isHandlerSignature(funcDecl *ast.FuncDecl) bool {
+	if !funcDecl.Name.IsExported() {
+		return false
+	}
+
+	for _, field := range funcDecl.Type.Params.List {
+		star, ok := field.Type.(*ast.StarExpr)
+		if !ok {
+			continue
+		}
+
+		var generic ast.Expr
+		switch t := star.X.(type) {
+		case *ast.IndexExpr:
+			generic = t.X
+		case *ast.IndexListExpr:
+			generic = t.X
+		}
+		if sel, ok := generic.(*ast.SelectorExpr); ok && connectParamTypes[sel.Sel.Name] {
+			return true
+		}
+	}
 	return false
 }
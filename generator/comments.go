@@ -0,0 +1,56 @@
+package generator
+
+import (
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Field numbers used in SourceCodeInfo paths, from descriptor.proto
+const (
+	fileMessageField   = 4 // FileDescriptorProto.message_type
+	fileEnumField      = 5 // FileDescriptorProto.enum_type
+	fileServiceField   = 6 // FileDescriptorProto.service
+	messageFieldField  = 2 // DescriptorProto.field
+	messageNestedField = 3 // DescriptorProto.nested_type
+	messageEnumField   = 4 // DescriptorProto.enum_type
+	messageOneofField  = 8 // DescriptorProto.oneof_decl
+	enumValueField     = 2 // EnumDescriptorProto.value
+	serviceMethodField = 2 // ServiceDescriptorProto.method
+)
+
+// servicePath returns the SourceCodeInfo path of a service in fd
+func servicePath(fd *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto) []int32 {
+	return []int32{fileServiceField, int32(slices.Index(fd.GetService(), svc))}
+}
+
+// methodPath returns the SourceCodeInfo path of a method of svc in fd
+func methodPath(fd *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto, method *descriptorpb.MethodDescriptorProto) []int32 {
+	return append(servicePath(fd, svc), serviceMethodField, int32(slices.Index(svc.GetMethod(), method)))
+}
+
+// sourceLocation returns the location of the element at path in fd, or nil
+// when fd carries no source info for it
+func sourceLocation(fd *descriptorpb.FileDescriptorProto, path []int32) *descriptorpb.SourceCodeInfo_Location {
+	for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+		if slices.Equal(loc.GetPath(), path) {
+			return loc
+		}
+	}
+	return nil
+}
+
+// commentDirective returns the value of a "name: value" line in the leading
+// or trailing comments of loc, e.g. "renamed_from: Echo"
+func commentDirective(loc *descriptorpb.SourceCodeInfo_Location, name string) string {
+	for _, comment := range []string{loc.GetLeadingComments(), loc.GetTrailingComments()} {
+		for line := range strings.SplitSeq(comment, "\n") {
+			value, ok := strings.CutPrefix(strings.TrimSpace(line), name+":")
+			if ok {
+				return strings.TrimSpace(value)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package generator
+
+import (
+	"path/filepath"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// deprecated reports whether an RPC of the request is deprecated, either
+// itself or through its service
+func (idx *typeIndex) deprecated(method *descriptorpb.MethodDescriptorProto) bool {
+	return method.GetOptions().GetDeprecated() || idx.deprecatedService(method) != ""
+}
+
+// deprecatedService returns the full name of the service of an RPC of the
+// request when the RPC is deprecated only because its service is, or ""
+func (idx *typeIndex) deprecatedService(method *descriptorpb.MethodDescriptorProto) string {
+	if method.GetOptions().GetDeprecated() {
+		return ""
+	}
+	decl, ok := idx.decls[method]
+	if !ok || len(decl.path) < 2 || int(decl.path[1]) >= len(decl.file.GetService()) {
+		return ""
+	}
+	svc := decl.file.GetService()[decl.path[1]]
+	if !svc.GetOptions().GetDeprecated() {
+		return ""
+	}
+	return idx.decls[svc].fullName
+}
+
+// skipDeprecatedRPCs leaves the deprecated RPCs nobody implemented out of the
+// manifest interface and marks them to get no stub, with deprecated_rpcs=skip
+func skipDeprecatedRPCs(svc *descriptorpb.ServiceDescriptorProto, ctx Context, pkg *packageIndex, opts *Options) Context {
+	if opts.DeprecatedRPCs != deprecatedSkip {
+		return ctx
+	}
+
+	skip := make(map[string]bool)
+	for _, method := range svc.GetMethod() {
+		if ctx.types.deprecated(method) && !pkg.hasMethod(ctx.StructName, method.GetName()) {
+			skip[method.GetName()] = true
+		}
+	}
+	if len(skip) == 0 {
+		return ctx
+	}
+
+	service := *ctx.Service
+	service.Methods = nil
+	for _, method := range ctx.Service.Methods {
+		if skip[method.Name] {
+			service.Skipped = append(service.Skipped, method)
+		} else {
+			service.Methods = append(service.Methods, method)
+		}
+	}
+	ctx.Service = &service
+	ctx.skip = skip
+	return ctx
+}
+
+// reportDeprecated summarizes on stderr the methods implementing RPCs that
+// are deprecated in the proto. Stubs generated by this run are not listed.
+func reportDeprecated(svc *descriptorpb.ServiceDescriptorProto, ctx Context, pkg *packageIndex, opts *Options) {
+	var implemented []*descriptorpb.MethodDescriptorProto
+	for _, method := range svc.GetMethod() {
+		loc, ok := pkg.lookup(ctx.StructName, method.GetName())
+		if ok && !loc.Generated && ctx.types.deprecated(method) {
+			implemented = append(implemented, method)
+		}
+	}
+	if len(implemented) == 0 {
+		return
+	}
+
+	warnf("%d implemented method(s) of %s are deprecated in the proto:", len(implemented), ctx.Service.FullName)
+	for _, method := range implemented {
+		loc, _ := pkg.lookup(ctx.StructName, method.GetName())
+		warnf("%s:%d: %s.%s implements deprecated RPC %s",
+			filepath.Join(opts.Out, ctx.Dir, loc.File), loc.Line, ctx.StructName, method.GetName(), ctx.types.decls[method].fullName)
+	}
+}
@@ -0,0 +1,172 @@
+package generator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// withDeprecated marks the test service, or one of its RPCs, deprecated
+func withDeprecated(req *pluginpb.CodeGeneratorRequest, rpc string) *pluginpb.CodeGeneratorRequest {
+	svc := req.GetProtoFile()[2].GetService()[0]
+	if rpc == "" {
+		svc.Options = &descriptorpb.ServiceOptions{Deprecated: proto.Bool(true)}
+		return req
+	}
+	for _, method := range svc.GetMethod() {
+		if method.GetName() == rpc {
+			method.Options = &descriptorpb.MethodOptions{Deprecated: proto.Bool(true)}
+		}
+	}
+	return req
+}
+
+func TestGenerateDeprecatedRPCs(t *testing.T) {
+	marker := "// Deprecated: RPC deprecated in test/v1/test_service.proto.\n"
+	serviceMarker := "// Deprecated: service test.v1.TestService deprecated in test/v1/test_service.proto.\n"
+	tests := []struct {
+		name       string
+		params     string
+		deprecated string              // RPC to deprecate, the whole service if empty
+		expected   map[string][]string // file -> substrings
+		missing    map[string][]string // file -> substrings that must not appear
+	}{
+		{
+			name:       "unimplemented stub",
+			params:     "out=gen,mode=per_method",
+			deprecated: "Echo",
+			expected: map[string][]string{
+				"test_service_handler.gen.go": {"\t" + marker + "\tEcho("},
+				"test_service_echo.go":        {marker + "func (t *TestServiceHandler) Echo(", `errors.New("Echo is deprecated")`},
+				"test_service_ping.go":        {`errors.New("Ping not implemented")`},
+			},
+			missing: map[string][]string{
+				"test_service_handler.gen.go": {"\t" + marker + "\tPing("},
+				"test_service_ping.go":        {"Deprecated:"},
+			},
+		},
+		{
+			name:       "skip",
+			params:     "out=gen,mode=per_method,deprecated_rpcs=skip",
+			deprecated: "Echo",
+			expected: map[string][]string{
+				"test_service_handler.gen.go": {"\tPing("},
+				"test_service_ping.go":        {"func (t *TestServiceHandler) Ping("},
+			},
+			missing: map[string][]string{
+				"test_service_handler.gen.go": {"Echo("},
+				"test_service_echo.go":        {"package"},
+			},
+		},
+		{
+			name:   "deprecated service",
+			params: "out=gen",
+			expected: map[string][]string{
+				"test_service_handler.gen.go": {
+					"// Deprecated: service deprecated in test/v1/test_service.proto.\ntype TestServiceServer interface",
+					"\t" + serviceMarker + "\tEcho(",
+					"\t" + serviceMarker + "\tPing(",
+				},
+				"test_service_handler.go": {
+					"// Deprecated: service deprecated in test/v1/test_service.proto.\ntype TestServiceHandler struct",
+					serviceMarker + "func (t *TestServiceHandler) Echo(",
+					`errors.New("Echo is deprecated")`,
+					`errors.New("Ping is deprecated")`,
+				},
+			},
+			missing: map[string][]string{
+				"test_service_handler.gen.go": {marker},
+				"test_service_handler.go":     {marker},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+
+			resp, err := Generate(withDeprecated(testRequestWithServices(tt.params), tt.deprecated))
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			files := make(map[string]string)
+			for _, f := range resp.File {
+				files[f.GetName()] = f.GetContent()
+			}
+
+			for name, substrings := range tt.expected {
+				for _, substr := range substrings {
+					if !strings.Contains(files[name], substr) {
+						t.Errorf("%s should contain %q\n%s", name, substr, files[name])
+					}
+				}
+			}
+			for name, substrings := range tt.missing {
+				for _, substr := range substrings {
+					if strings.Contains(files[name], substr) {
+						t.Errorf("%s should not contain %q\n%s", name, substr, files[name])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateReportsDeprecatedImplementations(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTestFiles(t, "gen", map[string]string{
+		"test_service_handler.go": `package test_v1
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	testv1 "example.com/gen/test/v1"
+)
+
+type TestServiceHandler struct{}
+
+// Echo is implemented
+func (t *TestServiceHandler) Echo(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {
+	return connect.NewResponse(req.Msg), nil
+}
+`,
+	})
+
+	var logs bytes.Buffer
+	prev := logOutput
+	logOutput = &logs
+	t.Cleanup(func() { logOutput = prev })
+
+	// Both RPCs are deprecated, but only Echo was implemented before this run
+	resp, err := Generate(withDeprecated(testRequestWithServices("out=gen,deprecated_rpcs=skip"), ""))
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+
+	for _, substr := range []string{
+		"1 implemented method(s) of test.v1.TestService are deprecated in the proto:",
+		"gen/test_service_handler.go:13: TestServiceHandler.Echo implements deprecated RPC test.v1.TestService.Echo",
+	} {
+		if !strings.Contains(logs.String(), substr) {
+			t.Errorf("stderr should contain %q:\n%s", substr, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "TestServiceHandler.Ping") {
+		t.Errorf("Ping is not implemented and should not be reported:\n%s", logs.String())
+	}
+
+	// The implemented RPC stays in the interface, the skipped one is left out
+	for _, f := range resp.File {
+		if f.GetName() != "test_service_handler.gen.go" {
+			continue
+		}
+		if !strings.Contains(f.GetContent(), "\tEcho(") || strings.Contains(f.GetContent(), "Ping(") {
+			t.Errorf("manifest should list Echo only\n%s", f.GetContent())
+		}
+	}
+}
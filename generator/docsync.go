@@ -0,0 +1,150 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// protoDocPrefix starts the line of a method's doc comment naming its RPC.
+// With sync_docs the generator owns that line, the proto comments it wrote
+// above it and the lines it wrote below it.
+const protoDocPrefix = "// Proto: "
+
+// syncDocs rewrites the generator-owned part of the doc comment of each
+// implemented method to match the current proto comments. The state file
+// records that part as it was last written: the proto comments following
+// the summary, the Proto line and what follows it, such as a Deprecated
+// paragraph. Lines above it and below it belong to the developer, except for
+// a summary the generator wrote itself and nobody has edited since, which
+// follows the proto too. Without a record the generator owns the doc from
+// the Proto line to its end, along with the comments right above that line
+// if they read as the generator would write them now. Methods whose doc has
+// no Proto line, or whose generator-owned part was edited, are left alone.
+func syncDocs(svc *descriptorpb.ServiceDescriptorProto, ctx Context, idx *typeIndex, pkg *packageIndex, state *serviceState, out *outputSet, opts *Options) error {
+	if !opts.SyncDocs {
+		return nil
+	}
+
+	for _, method := range svc.GetMethod() {
+		loc, ok := pkg.lookup(ctx.StructName, method.GetName())
+		if !ok || pkg.broken[loc.File] {
+			continue
+		}
+
+		path := filepath.Join(ctx.Dir, loc.File)
+		parsed, _, decl, err := parseMethod(out, path, ctx.StructName, method.GetName())
+		if err != nil {
+			return err
+		}
+		if decl == nil || decl.Doc == nil {
+			continue
+		}
+		current := docLines(decl.Doc)
+		protoLine := slices.IndexFunc(current, isProtoDocLine)
+		if protoLine < 0 {
+			continue
+		}
+
+		methodCtx := newMethodContext(method, idx, ctx.Imports)
+		rendered, err := executeTemplate("method_doc", ctx, methodCtx)
+		if err != nil {
+			return fmt.Errorf("failed to render doc comment of %s: %w", methodCtx.FullName, err)
+		}
+		renderedLines := strings.Split(rendered, "\n")
+		summary := docSummary(renderedLines)
+		owned := ownedDoc(renderedLines, summary)
+		if !slices.ContainsFunc(owned, isProtoDocLine) {
+			continue
+		}
+
+		// Find the generator-owned part of the current doc around its Proto line
+		recorded, _ := state.lookup(ctx.Service.FullName + "." + method.GetName())
+		start, end := protoLine, len(current)
+		if recorded.Doc != "" {
+			doc := strings.Split(recorded.Doc, "\n")
+			start = protoLine - max(slices.IndexFunc(doc, isProtoDocLine), 0)
+			end = start + len(doc)
+			if start < 0 || end > len(current) || !slices.Equal(current[start:end], doc) {
+				warnf("%s:%d: doc comment of %s.%s was edited around its Proto line; restore it or remove the Proto line",
+					filepath.Join(opts.Out, path), loc.Line, ctx.StructName, method.GetName())
+				loc.Doc = recorded.Doc
+				pkg.add(ctx.StructName, method.GetName(), loc)
+				continue
+			}
+		} else if above := owned[:slices.IndexFunc(owned, isProtoDocLine)]; protoLine >= len(above) &&
+			slices.Equal(current[protoLine-len(above):protoLine], above) {
+			start = protoLine - len(above)
+		}
+
+		lines := append(slices.Clone(current[:start]), owned...)
+		lines = append(lines, current[end:]...)
+		loc.Doc = strings.Join(owned, "\n")
+		currentSummary := docSummary(current[:start])
+		switch {
+		case slices.Equal(currentSummary, summary):
+			loc.Summary = strings.Join(summary, "\n")
+		case len(currentSummary) > 0 && recorded.Summary == strings.Join(currentSummary, "\n"):
+			lines = slices.Replace(lines, 0, len(currentSummary), summary...)
+			loc.Summary = strings.Join(summary, "\n")
+		}
+		pkg.add(ctx.StructName, method.GetName(), loc)
+
+		if slices.Equal(lines, current) {
+			continue
+		}
+		edit := textEdit{
+			offset: parsed.offset(decl.Doc.Pos()),
+			end:    parsed.offset(decl.Doc.End()),
+			text:   strings.Join(lines, "\n"),
+		}
+		content, err := formatGo(applyEdits(parsed.src, []textEdit{edit}), fmt.Sprintf("doc comment sync of %s in %s", method.GetName(), path))
+		if err != nil {
+			return err
+		}
+		out.write(path, content)
+		infof("%s:%d: synced doc comment of %s.%s", filepath.Join(opts.Out, path), loc.Line, ctx.StructName, method.GetName())
+	}
+	return nil
+}
+
+// docLines returns the lines of a doc comment, or nil if it is not made of
+// line comments only
+func docLines(doc *ast.CommentGroup) []string {
+	var lines []string
+	for _, c := range doc.List {
+		if !strings.HasPrefix(c.Text, "//") {
+			return nil
+		}
+		lines = append(lines, c.Text)
+	}
+	return lines
+}
+
+// isProtoDocLine reports whether a doc comment line names the method's RPC
+func isProtoDocLine(line string) bool {
+	return strings.HasPrefix(line, protoDocPrefix)
+}
+
+// ownedDoc returns the part of a rendered method doc the generator owns: the
+// lines following its summary and the blank line after it
+func ownedDoc(rendered, summary []string) []string {
+	owned := rendered[len(summary):]
+	if len(owned) > 0 && owned[0] == "//" {
+		owned = owned[1:]
+	}
+	return owned
+}
+
+// docSummary returns the first paragraph of doc comment lines
+func docSummary(lines []string) []string {
+	end := slices.Index(lines, "//")
+	if end < 0 {
+		end = len(lines)
+	}
+	return lines[:end]
+}
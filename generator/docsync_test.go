@@ -0,0 +1,256 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestGenerateSyncsDocComments(t *testing.T) {
+	header := `package test_v1
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	commonv1 "example.com/gen/common/v1"
+	testv1 "example.com/gen/test/v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type TestServiceHandler struct{}
+`
+	// The generator wrote Echo's summary, a developer added a paragraph
+	echo := `// Echo returns the input.
+//
+// Reviewed by the API team.
+//
+// Proto: test.v1.TestService.Echo (test/v1/old.proto)
+//
+// Old details.
+func (t *TestServiceHandler) Echo(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {
+	return connect.NewResponse(req.Msg), nil
+}`
+	syncedEcho := `// Echo echoes the input message.
+//
+// Reviewed by the API team.
+//
+// New details.
+//
+// Proto: test.v1.TestService.Echo (test/v1/test_service.proto)
+func (t *TestServiceHandler) Echo(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {
+	return connect.NewResponse(req.Msg), nil
+}`
+	pingFunc := `func (t *TestServiceHandler) Ping(ctx context.Context, req *connect.Request[emptypb.Empty]) (*connect.Response[commonv1.Status], error) {
+	return connect.NewResponse(&commonv1.Status{}), nil
+}`
+
+	tests := []struct {
+		name     string
+		params   string
+		pingDoc  string
+		expected []string // substrings of the handler file after the run
+		synced   bool     // whether Echo's doc is reported as synced
+	}{
+		{
+			name:    "developer summary kept",
+			params:  "out=gen,sync_docs=true",
+			pingDoc: "// Ping is written by hand.\n//\n// Proto: test.v1.TestService.Ping (test/v1/old.proto)\n",
+			expected: []string{
+				syncedEcho,
+				"// Ping is written by hand.\n//\n// Proto: test.v1.TestService.Ping (test/v1/test_service.proto)\n" + pingFunc,
+			},
+			synced: true,
+		},
+		{
+			name:     "no proto line",
+			params:   "out=gen,sync_docs=true",
+			pingDoc:  "// Ping pings.\n",
+			expected: []string{syncedEcho, "// Ping pings.\n" + pingFunc},
+			synced:   true,
+		},
+		{
+			name:     "disabled",
+			params:   "out=gen",
+			pingDoc:  "// Ping pings.\n",
+			expected: []string{echo, "// Ping pings.\n" + pingFunc},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			writeTestFiles(t, "gen", map[string]string{
+				"test_service_handler.go": header + "\n" + echo + "\n\n" + tt.pingDoc + pingFunc + "\n",
+				"test_service_handler.state.json": marshalState(serviceState{
+					Version: stateVersion,
+					Service: "test.v1.TestService",
+					Struct:  "TestServiceHandler",
+					Methods: []methodState{
+						{RPC: "test.v1.TestService.Echo", Method: "Echo", File: "test_service_handler.go", Summary: "// Echo returns the input."},
+						{RPC: "test.v1.TestService.Ping", Method: "Ping", File: "test_service_handler.go", Summary: "// Ping pings back."},
+					},
+				}),
+			})
+
+			var logs bytes.Buffer
+			prev := logOutput
+			logOutput = &logs
+			t.Cleanup(func() { logOutput = prev })
+
+			req := testRequestWithServices(tt.params)
+			req = withComment(req, []int32{6, 0, 2, 0}, " Echo echoes the input message.\n\n New details.\n")
+			resp, err := Generate(req)
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			files := make(map[string]string)
+			for _, f := range resp.File {
+				files[f.GetName()] = f.GetContent()
+			}
+
+			content, ok := files["test_service_handler.go"]
+			if !ok {
+				content = header + "\n" + echo + "\n\n" + tt.pingDoc + pingFunc + "\n"
+			}
+			for _, substr := range tt.expected {
+				if !strings.Contains(content, substr) {
+					t.Errorf("handler should contain %q\n%s", substr, content)
+				}
+			}
+			if got := strings.Contains(logs.String(), "synced doc comment of TestServiceHandler.Echo"); got != tt.synced {
+				t.Errorf("Echo sync reported = %v, want %v:\n%s", got, tt.synced, logs.String())
+			}
+
+			// Only the summary the generator wrote stays tracked
+			var state serviceState
+			if err := json.Unmarshal([]byte(files["test_service_handler.state.json"]), &state); err != nil {
+				t.Fatal(err)
+			}
+			echoState, _ := state.lookup("test.v1.TestService.Echo")
+			pingState, _ := state.lookup("test.v1.TestService.Ping")
+			wantSummary := ""
+			if tt.synced {
+				wantSummary = "// Echo echoes the input message."
+			}
+			if echoState.Summary != wantSummary || pingState.Summary != "" {
+				t.Errorf("recorded summaries Echo %q, Ping %q, want %q and none", echoState.Summary, pingState.Summary, wantSummary)
+			}
+		})
+	}
+}
+
+func TestGenerateSyncsDocKeepsDeveloperNotes(t *testing.T) {
+	header := `package test_v1
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	testv1 "example.com/gen/test/v1"
+)
+
+type TestServiceHandler struct{}
+`
+	echoFunc := `func (t *TestServiceHandler) Echo(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {
+	return connect.NewResponse(req.Msg), nil
+}`
+	// Records of the part the generator owns, written before and after
+	// the proto comments moved above the Proto line
+	recordedBelow := "// Proto: test.v1.TestService.Echo (test/v1/old.proto)\n//\n// Old details."
+	recordedAbove := "// Old details.\n//\n// Proto: test.v1.TestService.Echo (test/v1/old.proto)"
+	synced := "// New details.\n//\n// Proto: test.v1.TestService.Echo (test/v1/test_service.proto)"
+
+	tests := []struct {
+		name     string
+		doc      string
+		recorded string // Echo's doc recorded in the state file before the run
+		expected string // Echo's doc after the run
+		record   string // Echo's doc recorded in the state file after the run
+		warning  string
+	}{
+		{
+			name:     "notes below the synced part",
+			doc:      "// Echo echoes.\n//\n" + recordedBelow + "\n//\n// Note: responses are cached for a minute.\n",
+			recorded: recordedBelow,
+			expected: "// Echo echoes.\n//\n" + synced + "\n//\n// Note: responses are cached for a minute.\n",
+			record:   synced,
+			warning:  "synced doc comment of TestServiceHandler.Echo",
+		},
+		{
+			name:     "notes around the synced part",
+			doc:      "// Echo echoes.\n//\n// Reviewed.\n//\n" + recordedAbove + "\n//\n// Note: responses are cached for a minute.\n",
+			recorded: recordedAbove,
+			expected: "// Echo echoes.\n//\n// Reviewed.\n//\n" + synced + "\n//\n// Note: responses are cached for a minute.\n",
+			record:   synced,
+			warning:  "synced doc comment of TestServiceHandler.Echo",
+		},
+		{
+			name:     "generated doc without a record",
+			doc:      "// Echo echoes.\n//\n" + synced + "\n",
+			expected: "// Echo echoes.\n//\n" + synced + "\n",
+			record:   synced,
+		},
+		{
+			name:     "synced part edited",
+			doc:      "// Echo echoes.\n//\n// Details rewritten by hand.\n//\n// Proto: test.v1.TestService.Echo (test/v1/old.proto)\n",
+			recorded: recordedAbove,
+			expected: "// Echo echoes.\n//\n// Details rewritten by hand.\n//\n// Proto: test.v1.TestService.Echo (test/v1/old.proto)\n",
+			record:   recordedAbove,
+			warning:  "doc comment of TestServiceHandler.Echo was edited around its Proto line",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			writeTestFiles(t, "gen", map[string]string{
+				"test_service_handler.go": header + "\n" + tt.doc + echoFunc + "\n",
+				"test_service_handler.state.json": marshalState(serviceState{
+					Version: stateVersion,
+					Service: "test.v1.TestService",
+					Struct:  "TestServiceHandler",
+					Methods: []methodState{
+						{RPC: "test.v1.TestService.Echo", Method: "Echo", File: "test_service_handler.go", Doc: tt.recorded},
+					},
+				}),
+			})
+
+			var logs bytes.Buffer
+			prev := logOutput
+			logOutput = &logs
+			t.Cleanup(func() { logOutput = prev })
+
+			req := testRequestWithServices("out=gen,sync_docs=true")
+			req = withComment(req, []int32{6, 0, 2, 0}, " Echo echoes.\n\n New details.\n")
+			resp, err := Generate(req)
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			files := make(map[string]string)
+			for _, f := range resp.File {
+				files[f.GetName()] = f.GetContent()
+			}
+
+			content, ok := files["test_service_handler.go"]
+			if !ok {
+				content = header + "\n" + tt.doc + echoFunc + "\n"
+			}
+			if !strings.Contains(content, tt.expected+echoFunc) {
+				t.Errorf("handler should contain %q\n%s", tt.expected+echoFunc, content)
+			}
+			if !strings.Contains(logs.String(), tt.warning) {
+				t.Errorf("stderr should contain %q:\n%s", tt.warning, logs.String())
+			}
+
+			var state serviceState
+			if err := json.Unmarshal([]byte(files["test_service_handler.state.json"]), &state); err != nil {
+				t.Fatal(err)
+			}
+			if echoState, _ := state.lookup("test.v1.TestService.Echo"); echoState.Doc != tt.record {
+				t.Errorf("recorded doc %q, want %q", echoState.Doc, tt.record)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"go/format"
+	"go/scanner"
+	"strings"
+)
+
+// formatGo runs gofmt on generated Go source. origin describes what produced
+// src, e.g. `template "method_stub" for service TestService`, and is used to
+// report source that does not parse.
+func formatGo(src, origin string) (string, error) {
+	formatted, err := format.Source([]byte(src))
+	if err != nil {
+		return "", invalidGoError(src, origin, 0, err)
+	}
+	return string(formatted), nil
+}
+
+// validateGoDecls checks that a fragment of top-level declarations, such as
+// a rendered method, parses on its own
+func validateGoDecls(fragment, origin string) error {
+	const header = "package p\n\n"
+	if _, err := format.Source([]byte(header + fragment)); err != nil {
+		return invalidGoError(header+fragment, origin, strings.Count(header, "\n"), err)
+	}
+	return nil
+}
+
+// invalidGoError reports the first parse error in src with the offending
+// line. lineOffset is subtracted from reported line numbers to account for
+// wrapping added around fragments.
+func invalidGoError(src, origin string, lineOffset int, err error) error {
+	var list scanner.ErrorList
+	if !errors.As(err, &list) || len(list) == 0 {
+		return fmt.Errorf("%s produced invalid Go: %w", origin, err)
+	}
+
+	first := list[0]
+	line := ""
+	if lines := strings.Split(src, "\n"); first.Pos.Line >= 1 && first.Pos.Line <= len(lines) {
+		line = strings.TrimSpace(lines[first.Pos.Line-1])
+	}
+	return fmt.Errorf("%s produced invalid Go at line %d: %s\n\t%s",
+		origin, first.Pos.Line-lineOffset, first.Msg, line)
+}
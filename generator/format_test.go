@@ -0,0 +1,139 @@
+package generator
+
+import (
+	"go/format"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func TestFormatGo(t *testing.T) {
+	input := "package handler\nimport (\n\t\"context\"   \n)\nvar _   context.Context\n"
+	expected := "package handler\n\nimport (\n\t\"context\"\n)\n\nvar _ context.Context\n"
+
+	result, err := formatGo(input, "test")
+	if err != nil {
+		t.Fatalf("formatGo() failed: %v", err)
+	}
+	if result != expected {
+		t.Errorf("formatGo() =\n%q\nwant\n%q", result, expected)
+	}
+}
+
+func TestFormatGoInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		validate func() error
+		expected []string
+	}{
+		{
+			name: "file",
+			validate: func() error {
+				_, err := formatGo("package handler\n\nfunc broken( {\n}\n", `template "struct_stub" for service TestService`)
+				return err
+			},
+			expected: []string{`template "struct_stub" for service TestService`, "line 3", "func broken( {"},
+		},
+		{
+			name: "fragment",
+			validate: func() error {
+				return validateGoDecls("// Echo\nfunc (h *H) Echo() {\n\tx := := 1\n}", `template "method_only"`)
+			},
+			expected: []string{`template "method_only"`, "line 3", "x := := 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.validate()
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			for _, want := range tt.expected {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q should contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateRejectsInvalidTemplateOutput(t *testing.T) {
+	templateDir := t.TempDir()
+	writeTestFiles(t, templateDir, map[string]string{
+		TEMPLATE_SERVICE + ".tmpl": "package {{.PackageName}}\n\ntype {{.Service.Name}}Server interface {\n\tEcho(\n}\n",
+	})
+
+	fileName := "test/v1/test_service.proto"
+	pkg := "test.v1"
+	serviceName := "TestService"
+	parameter := "out=gen,template_dir=" + templateDir
+	_, err := Generate(&pluginpb.CodeGeneratorRequest{
+		Parameter:      &parameter,
+		FileToGenerate: []string{fileName},
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			{
+				Name:    &fileName,
+				Package: &pkg,
+				Service: []*descriptorpb.ServiceDescriptorProto{{Name: &serviceName}},
+			},
+		},
+	})
+	if err == nil {
+		t.Fatal("Generate() expected error, got nil")
+	}
+	for _, want := range []string{`template "service_manifest"`, "for service TestService", "line 5"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q should contain %q", err, want)
+		}
+	}
+}
+
+func TestGenerateOutputIsFormatted(t *testing.T) {
+	for _, parameter := range []string{"out=gen", "out=gen,mode=per_method"} {
+		req := testRequestWithServices(parameter)
+		resp, err := Generate(req)
+		if err != nil {
+			t.Fatalf("Generate() failed: %v", err)
+		}
+		for _, file := range resp.File {
+			if !strings.HasSuffix(file.GetName(), ".go") {
+				continue
+			}
+			formatted, err := format.Source([]byte(file.GetContent()))
+			if err != nil {
+				t.Fatalf("%s does not parse: %v", file.GetName(), err)
+			}
+			if string(formatted) != file.GetContent() {
+				t.Errorf("%s is not gofmt-formatted:\n%s", file.GetName(), file.GetContent())
+			}
+		}
+	}
+}
+
+// testRequestWithServices builds a request with a service using the test
+// proto files, plus a service without methods
+func testRequestWithServices(parameter string) *pluginpb.CodeGeneratorRequest {
+	protoFiles := testProtoFiles()
+	echo, ping := "Echo", "Ping"
+	echoRequest, empty, status := ".test.v1.EchoRequest", ".google.protobuf.Empty", ".common.v1.Status"
+	testService, emptyService := "TestService", "EmptyService"
+	protoFiles[2].Service = []*descriptorpb.ServiceDescriptorProto{
+		{
+			Name: &testService,
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: &echo, InputType: &echoRequest, OutputType: &echoRequest},
+				{Name: &ping, InputType: &empty, OutputType: &status},
+			},
+		},
+		{Name: &emptyService},
+	}
+
+	return &pluginpb.CodeGeneratorRequest{
+		Parameter:      &parameter,
+		FileToGenerate: []string{protoFiles[2].GetName()},
+		ProtoFile:      protoFiles,
+	}
+}
@@ -0,0 +1,213 @@
+package generator
+
+import (
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+)
+
+// docWidth is the column goDoc wraps comments at
+const docWidth = 80
+
+// templateFuncs returns the functions available to every template. Type
+// queries resolve proto names through idx.
+func templateFuncs(idx *typeIndex) template.FuncMap {
+	return template.FuncMap{
+		// Case conversions
+		"toSnakeCase":  toSnakeWords,
+		"toKebabCase":  toKebabCase,
+		"toLowerCamel": toLowerCamel,
+		"toUpperCamel": goCamelCase,
+		"pluralize":    pluralize,
+
+		// Formatting
+		"goDoc":        goDoc,
+		"comment":      comment,
+		"docParagraph": docParagraph,
+		"paragraphs":   paragraphs,
+		"quote":        strconv.Quote,
+		"indent":       indent,
+
+		// Imports
+		"importAlias": importAlias,
+
+		// Descriptor queries
+		"isStreaming": isStreaming,
+		"fieldsOf": func(protoType string) []*FieldContext {
+			return fieldsOf(idx, protoType)
+		},
+		"message": idx.message,
+	}
+}
+
+// toSnakeWords converts a CamelCase or snake_case name to snake_case,
+// keeping acronyms whole ("HTTPServer" -> "http_server"). File names keep
+// using toSnakeCase, which splits every capital.
+func toSnakeWords(s string) string {
+	return strings.Join(splitWords(s), "_")
+}
+
+// toKebabCase converts a CamelCase or snake_case name to kebab-case,
+// keeping acronyms whole ("HTTPServer" -> "http-server")
+func toKebabCase(s string) string {
+	return strings.Join(splitWords(s), "-")
+}
+
+// splitWords splits a CamelCase or snake_case name into lower case words. A
+// word starts after an underscore, at a capital following a lower case
+// letter or digit, and at the last capital of an acronym followed by a word.
+func splitWords(s string) []string {
+	var words []string
+	var word []rune
+	runes := []rune(goCamelCase(s))
+	for i, r := range runes {
+		if r == '_' {
+			if len(word) > 0 {
+				words = append(words, string(word))
+			}
+			word = nil
+			continue
+		}
+		if len(word) > 0 && unicode.IsUpper(r) &&
+			(!unicode.IsUpper(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			words = append(words, string(word))
+			word = nil
+		}
+		word = append(word, unicode.ToLower(r))
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
+
+// toLowerCamel converts a CamelCase or snake_case name to lowerCamelCase,
+// lowering a leading acronym as a whole ("HTTPServer" -> "httpServer")
+func toLowerCamel(s string) string {
+	runes := []rune(goCamelCase(s))
+	for i := range runes {
+		if !unicode.IsUpper(runes[i]) {
+			break
+		}
+		// Keep the last capital of an acronym followed by a word
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
+
+// pluralize returns the English plural of a singular noun
+func pluralize(word string) string {
+	lower := strings.ToLower(word)
+	switch {
+	case word == "":
+		return ""
+	case strings.HasSuffix(lower, "s"), strings.HasSuffix(lower, "x"), strings.HasSuffix(lower, "z"),
+		strings.HasSuffix(lower, "ch"), strings.HasSuffix(lower, "sh"):
+		return word + "es"
+	case len(lower) > 1 && lower[len(lower)-1] == 'y' && !strings.ContainsRune("aeiou", rune(lower[len(lower)-2])):
+		return word[:len(word)-1] + "ies"
+	default:
+		return word + "s"
+	}
+}
+
+// goDoc turns text into a Go comment, wrapping each paragraph at docWidth
+// columns. Blank lines separate paragraphs.
+func goDoc(text string) string {
+	var lines []string
+	for i, paragraph := range strings.Split(strings.TrimSpace(text), "\n\n") {
+		if i > 0 {
+			lines = append(lines, "//")
+		}
+		line := "//"
+		for _, word := range strings.Fields(paragraph) {
+			if line != "//" && len(line)+1+len(word) > docWidth {
+				lines = append(lines, line)
+				line = "//"
+			}
+			line += " " + word
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// comment turns text into a Go comment line by line, keeping its line breaks
+func comment(text string) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("// "+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// docParagraph turns a paragraph into a Go comment like comment, ending a
+// lone line that gofmt would take for a heading with a period
+func docParagraph(text string) string {
+	text = strings.TrimRight(text, "\n")
+	if looksLikeHeading(text) {
+		text += "."
+	}
+	return comment(text)
+}
+
+// looksLikeHeading reports whether go/doc reads text as a heading when it
+// stands alone between two paragraphs: a single line starting with a capital
+// and ending in a letter or digit, without the characters headings exclude
+func looksLikeHeading(text string) bool {
+	if text == "" || strings.Contains(text, "\n") {
+		return false
+	}
+	first, _ := utf8.DecodeRuneInString(text)
+	last, _ := utf8.DecodeLastRuneInString(text)
+	return unicode.IsUpper(first) && (unicode.IsLetter(last) || unicode.IsDigit(last)) &&
+		!strings.ContainsAny(text, ";:!?+*/=[]{}_^°&§~%#@<\">\\")
+}
+
+// paragraphs splits text into its paragraphs, separated by blank lines
+func paragraphs(text string) []string {
+	var result []string
+	for paragraph := range strings.SplitSeq(text, "\n\n") {
+		if paragraph = strings.Trim(paragraph, "\n"); paragraph != "" {
+			result = append(result, paragraph)
+		}
+	}
+	return result
+}
+
+// indent prefixes every non-blank line of s with n tabs
+func indent(n int, s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			lines[i] = strings.Repeat("\t", n) + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// importAlias returns the name a file refers to the package at importPath
+// by, registering the import if the file does not have it yet
+func importAlias(imports *Imports, importPath string) string {
+	return imports.Add(importPath, "")
+}
+
+// isStreaming reports whether an RPC streams requests, responses or both
+func isStreaming(method *MethodContext) bool {
+	return method != nil && method.StreamType != streamUnary
+}
+
+// fieldsOf returns the fields of a message by proto full name, or nil if the
+// message is not part of the request
+func fieldsOf(idx *typeIndex, protoType string) []*FieldContext {
+	msg := idx.message(protoType)
+	if msg == nil {
+		return nil
+	}
+	return msg.Fields
+}
@@ -0,0 +1,359 @@
+package generator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestCaseConversions(t *testing.T) {
+	tests := []struct {
+		input                      string
+		snake, kebab, lower, upper string
+	}{
+		{"EchoRequest", "echo_request", "echo-request", "echoRequest", "EchoRequest"},
+		{"Echo", "echo", "echo", "echo", "Echo"},
+		{"HTTPServer", "http_server", "http-server", "httpServer", "HTTPServer"},
+		{"user_id", "user_id", "user-id", "userId", "UserId"},
+		{"ID", "id", "id", "id", "ID"},
+		{"GetHTTPResponse", "get_http_response", "get-http-response", "getHTTPResponse", "GetHTTPResponse"},
+		{"Outer.Inner", "outer_inner", "outer-inner", "outer_Inner", "Outer_Inner"},
+		{"oauth2_token", "oauth2_token", "oauth2-token", "oauth2Token", "Oauth2Token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := toSnakeWords(tt.input); got != tt.snake {
+				t.Errorf("toSnakeWords(%q) = %q, want %q", tt.input, got, tt.snake)
+			}
+			if got := toKebabCase(tt.input); got != tt.kebab {
+				t.Errorf("toKebabCase(%q) = %q, want %q", tt.input, got, tt.kebab)
+			}
+			if got := toLowerCamel(tt.input); got != tt.lower {
+				t.Errorf("toLowerCamel(%q) = %q, want %q", tt.input, got, tt.lower)
+			}
+			if got := goCamelCase(tt.input); got != tt.upper {
+				t.Errorf("toUpperCamel(%q) = %q, want %q", tt.input, got, tt.upper)
+			}
+		})
+	}
+}
+
+func TestPluralize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"User", "Users"},
+		{"Address", "Addresses"},
+		{"Box", "Boxes"},
+		{"Match", "Matches"},
+		{"Wish", "Wishes"},
+		{"Policy", "Policies"},
+		{"Key", "Keys"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := pluralize(tt.input); got != tt.expected {
+				t.Errorf("pluralize(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGoDoc(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "short",
+			input:    "Echo returns the request.\n",
+			expected: "// Echo returns the request.",
+		},
+		{
+			name:  "wrapped",
+			input: strings.Repeat("word ", 20),
+			expected: "// word word word word word word word word word word word word word word word\n" +
+				"// word word word word word",
+		},
+		{
+			name:     "paragraphs",
+			input:    "First line\ncontinues.\n\nSecond paragraph.",
+			expected: "// First line continues.\n//\n// Second paragraph.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := goDoc(tt.input); got != tt.expected {
+				t.Errorf("goDoc() =\n%s\nwant\n%s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestComment(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"single line", "Echo echoes.", "// Echo echoes."},
+		{"line breaks kept", "Echo echoes\nwhat it gets.\n", "// Echo echoes\n// what it gets."},
+		{"blank line", "First.\n\nSecond.", "// First.\n//\n// Second."},
+		{"indented", "List:\n  - item", "// List:\n//   - item"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := comment(tt.input); got != tt.expected {
+				t.Errorf("comment(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDocParagraph(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"sentence", "Echo echoes.", "// Echo echoes."},
+		{"would be a heading", "See the API guide", "// See the API guide."},
+		{"ends in a digit", "Added in version 2", "// Added in version 2."},
+		{"lower case", "see the API guide", "// see the API guide"},
+		{"punctuation", "Returns the input (unchanged)", "// Returns the input (unchanged)"},
+		{"excluded characters", "Note: read the guide", "// Note: read the guide"},
+		{"several lines", "See the\nAPI guide", "// See the\n// API guide"},
+		{"trailing line break", "See the API guide\n", "// See the API guide."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := docParagraph(tt.input); got != tt.expected {
+				t.Errorf("docParagraph(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParagraphs(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"", nil},
+		{"One line", []string{"One line"}},
+		{"First\nparagraph.\n\nSecond.\n", []string{"First\nparagraph.", "Second."}},
+		{"First.\n\n\n\nSecond.", []string{"First.", "Second."}},
+	}
+
+	for _, tt := range tests {
+		if got := paragraphs(tt.input); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("paragraphs(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestIndent(t *testing.T) {
+	tests := []struct {
+		name     string
+		n        int
+		input    string
+		expected string
+	}{
+		{"single line", 1, "return nil", "\treturn nil"},
+		{"blank lines untouched", 2, "a\n\nb\n", "\t\ta\n\n\t\tb\n"},
+		{"zero", 0, "a\nb", "a\nb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := indent(tt.n, tt.input); got != tt.expected {
+				t.Errorf("indent(%d, %q) = %q, want %q", tt.n, tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"plain", "Echo", `"Echo"`},
+		{"empty", "", `""`},
+		{"quotes and backslashes", `say "hi" \ bye`, `"say \"hi\" \\ bye"`},
+		{"line breaks and tabs", "a\n\tb", `"a\n\tb"`},
+		{"non-printable", "\x00\u2028", `"\x00\u2028"`},
+		{"unicode kept", "héllo", `"héllo"`},
+	}
+
+	quote := templateFuncs(nil)["quote"].(func(string) string)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quote(tt.input); got != tt.expected {
+				t.Errorf("quote(%q) = %s, want %s", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMessage(t *testing.T) {
+	idx := newTypeIndex(testProtoFiles(), &Options{})
+
+	tests := []struct {
+		name     string
+		idx      *typeIndex
+		input    string
+		expected string // GoName of the message, empty for nil
+	}{
+		{"top level", idx, "test.v1.EchoRequest", "EchoRequest"},
+		{"leading dot", idx, ".test.v1.EchoRequest", "EchoRequest"},
+		{"nested", idx, "test.v1.Outer.Inner", "Outer_Inner"},
+		{"other package", idx, "common.v1.Status", "Status"},
+		{"enum", idx, "test.v1.Outer.Kind", ""},
+		{"missing", idx, "test.v1.Missing", ""},
+		{"no index", nil, "test.v1.EchoRequest", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := templateFuncs(tt.idx)["message"].(func(string) *MessageContext)
+			got := message(tt.input)
+			switch {
+			case tt.expected == "" && got != nil:
+				t.Errorf("message(%q) = %+v, want nil", tt.input, got)
+			case tt.expected != "" && (got == nil || got.GoName != tt.expected):
+				t.Errorf("message(%q) = %+v, want GoName %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestImportAlias(t *testing.T) {
+	im := newImports()
+	im.Add("example.com/gen/test/v1", "testv1")
+
+	tests := []struct {
+		importPath string
+		expected   string
+	}{
+		{"connectrpc.com/connect", "connect"},
+		{"example.com/gen/test/v1", "testv1"},
+		{"log/slog", "slog"},
+		{"example.com/other/slog", "slog1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.importPath, func(t *testing.T) {
+			if got := importAlias(im, tt.importPath); got != tt.expected {
+				t.Errorf("importAlias(%q) = %q, want %q", tt.importPath, got, tt.expected)
+			}
+		})
+	}
+	if _, ok := im.byPath["log/slog"]; !ok {
+		t.Error("importAlias() should register new imports")
+	}
+}
+
+func TestIsStreaming(t *testing.T) {
+	tests := []struct {
+		method   *MethodContext
+		expected bool
+	}{
+		{&MethodContext{StreamType: streamUnary}, false},
+		{&MethodContext{StreamType: streamClient}, true},
+		{&MethodContext{StreamType: streamServer}, true},
+		{&MethodContext{StreamType: streamBidi}, true},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := isStreaming(tt.method); got != tt.expected {
+			t.Errorf("isStreaming(%+v) = %v, want %v", tt.method, got, tt.expected)
+		}
+	}
+}
+
+func TestFieldsOf(t *testing.T) {
+	files := testProtoFiles()
+	files = append(files, &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("user/v1/user.proto"),
+		Package: proto.String("user.v1"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("User"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{
+					Name:     proto.String("user_id"),
+					JsonName: proto.String("userId"),
+					Number:   proto.Int32(1),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				},
+				{
+					Name:     proto.String("statuses"),
+					JsonName: proto.String("statuses"),
+					Number:   proto.Int32(2),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+					TypeName: proto.String(".common.v1.Status"),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+				},
+				{
+					Name:           proto.String("nickname"),
+					JsonName:       proto.String("nickname"),
+					Number:         proto.Int32(3),
+					Type:           descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+					Proto3Optional: proto.Bool(true),
+				},
+			},
+		}},
+	})
+	idx := newTypeIndex(files, &Options{})
+
+	loc := SourceLocation{File: "user/v1/user.proto"}
+	expected := []*FieldContext{
+		{Name: "user_id", GoName: "UserId", JSONName: "userId", Number: 1, Type: "string", Location: loc},
+		{Name: "statuses", GoName: "Statuses", JSONName: "statuses", Number: 2, Type: "message", TypeName: "common.v1.Status", Repeated: true, Location: loc},
+		{Name: "nickname", GoName: "Nickname", JSONName: "nickname", Number: 3, Type: "string", Optional: true, Location: loc},
+	}
+	for _, protoType := range []string{"user.v1.User", ".user.v1.User"} {
+		if got := fieldsOf(idx, protoType); !reflect.DeepEqual(got, expected) {
+			t.Errorf("fieldsOf(%q) = %+v, want %+v", protoType, got, expected)
+		}
+	}
+
+	for _, protoType := range []string{"user.v1.Missing", "test.v1.Outer.Kind", "google.protobuf.Empty"} {
+		if got := fieldsOf(idx, protoType); got != nil {
+			t.Errorf("fieldsOf(%q) = %+v, want nil", protoType, got)
+		}
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	// Every function can be called from a template
+	tmpl := template.Must(template.New("").Funcs(templateFuncs(nil)).Parse(
+		`{{toSnakeCase "EchoRequest"}} {{toKebabCase "EchoRequest"}} {{toLowerCamel "EchoRequest"}} ` +
+			`{{toUpperCamel "echo_request"}} {{pluralize "Status"}} {{quote "a"}} {{indent 1 "x"}} ` +
+			`{{goDoc "doc"}} {{importAlias .Imports "log/slog"}} {{isStreaming .Method}} {{len (fieldsOf "x.Y")}}`))
+
+	var b strings.Builder
+	ctx := Context{Imports: newImports(), Method: &MethodContext{StreamType: streamServer}}
+	if err := tmpl.Execute(&b, ctx); err != nil {
+		t.Fatalf("Execute() failed: %v", err)
+	}
+	expected := "echo_request echo-request echoRequest EchoRequest Statuses \"a\" \tx // doc slog true 0"
+	if b.String() != expected {
+		t.Errorf("template output = %q, want %q", b.String(), expected)
+	}
+}
@@ -2,6 +2,8 @@ package generator
 
 import (
 	"fmt"
+	"go/parser"
+	"go/token"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,6 +17,17 @@ const (
 	TEMPLATE_METHOD_ONLY = "method_only"
 	TEMPLATE_SERVICE     = "service_manifest"
 	TEMPLATE_STRUCT      = "struct_stub"
+	TEMPLATE_REGISTER    = "register"
+	TEMPLATE_REGISTRY    = "registry"
+	TEMPLATE_SERVER      = "server_main"
+	TEMPLATE_TEST        = "method_test"
+)
+
+const (
+	streamUnary  = "unary"
+	streamClient = "client_streaming"
+	streamServer = "server_streaming"
+	streamBidi   = "bidi_streaming"
 )
 
 // Generate processes the CodeGeneratorRequest and returns a CodeGeneratorResponse
@@ -25,7 +38,11 @@ func Generate(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorRespon
 		return nil, err
 	}
 
-	var files []*pluginpb.CodeGeneratorResponse_File
+	out := newOutputSet(opts.Out)
+	reg := &registry{}
+
+	// Index every proto file so types from other packages can be resolved
+	idx := newTypeIndex(req.GetProtoFile(), opts)
 
 	// Process each file to generate
 	for _, fileName := range req.GetFileToGenerate() {
@@ -43,183 +60,274 @@ func Generate(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorRespon
 
 		// Process each service in the file
 		for _, svc := range fileDesc.GetService() {
-			generatedFiles, err := generateServiceFiles(fileDesc, svc, opts)
+			ctx, err := generateServiceFiles(fileDesc, svc, idx, out, opts)
 			if err != nil {
 				return nil, fmt.Errorf("failed to generate files for service %s: %w", svc.GetName(), err)
 			}
-			files = append(files, generatedFiles...)
+			reg.add(svc, ctx)
+		}
+	}
+
+	// Mount every service of the run from one file, if asked
+	if opts.RegistryOut != "" {
+		if err := generateRegistryFile(reg, idx, out, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	// Scaffold a server for the services of the run, once
+	if opts.ServerScaffold != "" {
+		if err := generateServerScaffold(reg, idx, out, opts); err != nil {
+			return nil, err
 		}
 	}
 
 	return &pluginpb.CodeGeneratorResponse{
-		File: files,
+		File: out.responseFiles(),
 	}, nil
 }
 
-// generateServiceFiles generates all files for a single service
-func generateServiceFiles(fileDesc *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto, opts *Options) ([]*pluginpb.CodeGeneratorResponse_File, error) {
-	ctx := buildContext(fileDesc, svc, opts)
-	var files []*pluginpb.CodeGeneratorResponse_File
+// generateServiceFiles generates all files for a single service and returns
+// the context it generated them with
+func generateServiceFiles(fileDesc *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto, idx *typeIndex, out *outputSet, opts *Options) (Context, error) {
+	ctx, err := buildContext(fileDesc, svc, idx, opts)
+	if err != nil {
+		return Context{}, err
+	}
 
-	// 1. Generate manifest file (always regenerated)
-	manifestFiles, err := generateManifestFile(ctx)
+	// Index the methods already implemented anywhere in the handler package
+	pkg, err := loadPackageIndex(constructFullPath(opts.Out, ctx.Dir))
 	if err != nil {
-		return nil, err
+		return ctx, fmt.Errorf("%s: %w", ctx.Dir, err)
 	}
-	files = append(files, manifestFiles...)
 
-	// 2. Generate struct file and methods based on mode
-	if opts.Mode == modePerMethod {
-		structFiles, err := generateStructFileIfNeeded(ctx, opts)
-		if err != nil {
-			return nil, err
+	// Follow renamed services and RPCs before looking for missing methods
+	state, err := readState(out, ctx.StatePath)
+	if err != nil {
+		return ctx, err
+	}
+	if err := applyRenames(fileDesc, svc, ctx, idx, pkg, state, out, opts); err != nil {
+		return ctx, err
+	}
+
+	// Regenerate stubs nobody has edited since they were generated
+	if err := refreshStubs(svc, ctx, idx, pkg, state, out, opts); err != nil {
+		return ctx, err
+	}
+
+	// Leave deprecated RPCs nobody implemented out, with deprecated_rpcs=skip
+	ctx = skipDeprecatedRPCs(svc, ctx, pkg, opts)
+
+	// 1. Generate manifest file (always regenerated)
+	if err := generateManifestFile(ctx, out); err != nil {
+		return ctx, err
+	}
+
+	// Mount the handler through the connect-go generated package, if asked
+	if opts.Register {
+		if err := generateRegisterFile(ctx, out); err != nil {
+			return ctx, err
 		}
-		files = append(files, structFiles...)
+	}
 
-		methodFiles, err := generatePerMethodFiles(fileDesc, svc, ctx, opts)
-		if err != nil {
-			return nil, err
+	// 2. Generate struct file and methods based on mode, once every file
+	// of the package parses so no method is stubbed twice
+	if !pkg.complete() {
+		warnf("%s: not generating stubs for %s until every file of %s parses",
+			ctx.Service.FullName, ctx.StructName, filepath.Join(opts.Out, ctx.Dir))
+	} else if opts.Mode == modePerMethod {
+		if err := generateStructFileIfNeeded(ctx, out); err != nil {
+			return ctx, err
+		}
+		if err := generatePerMethodFiles(svc, ctx, idx, pkg, out); err != nil {
+			return ctx, err
 		}
-		files = append(files, methodFiles...)
 	} else {
-		structFiles, err := generatePerServiceStructFile(fileDesc, svc, ctx, opts)
-		if err != nil {
-			return nil, err
+		if err := generatePerServiceStructFile(svc, ctx, idx, pkg, out); err != nil {
+			return ctx, err
+		}
+	}
+
+	// Scaffold a test for each RPC stubbed in this run, with tests=true
+	if pkg.complete() {
+		if err := generateTestFiles(svc, ctx, idx, pkg, out, opts); err != nil {
+			return ctx, err
 		}
-		files = append(files, structFiles...)
 	}
 
-	return files, nil
+	// 3. Check that implemented methods still match their RPC
+	if err := checkSignatures(fileDesc, svc, ctx, idx, pkg, out, opts); err != nil {
+		return ctx, err
+	}
+
+	// 4. Report implemented methods whose RPC is now deprecated
+	reportDeprecated(svc, ctx, pkg, opts)
+
+	// 5. Sync doc comments of implemented methods with the proto
+	if err := syncDocs(svc, ctx, idx, pkg, state, out, opts); err != nil {
+		return ctx, err
+	}
+
+	// 6. Report methods whose RPC was removed from the proto
+	if err := handleOrphans(fileDesc, svc, ctx, pkg, out, opts); err != nil {
+		return ctx, err
+	}
+
+	// 7. Record where each RPC is implemented for later runs; the state of
+	// a package that does not parse is kept until it does
+	if !pkg.complete() {
+		return ctx, nil
+	}
+	return ctx, writeState(svc, ctx, pkg, out)
 }
 
 // generateManifestFile generates the service manifest file
-func generateManifestFile(ctx Context) ([]*pluginpb.CodeGeneratorResponse_File, error) {
-	manifestContent, err := renderTemplate(TEMPLATE_SERVICE, ctx)
+func generateManifestFile(ctx Context, out *outputSet) error {
+	manifestContent, err := renderGoFile(TEMPLATE_SERVICE, ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to render manifest template: %w", err)
+		return fmt.Errorf("failed to render manifest template: %w", err)
 	}
 
-	return []*pluginpb.CodeGeneratorResponse_File{
-		{
-			Name:    &ctx.ManifestPath,
-			Content: &manifestContent,
-		},
-	}, nil
+	out.write(ctx.ManifestPath, manifestContent)
+	return nil
+}
+
+// generateRegisterFile generates the file registering the service's handler
+// on an http.ServeMux (always regenerated)
+func generateRegisterFile(ctx Context, out *outputSet) error {
+	// The imports of this file are its own
+	ctx.Imports = ctx.Imports.clone()
+	ctx.Imports.Add("net/http", "")
+	// Without a package suffix connect-go writes into the messages' package
+	name := ""
+	if pkg := ctx.types.goPackages[ctx.File.Name]; pkg.ImportPath == ctx.ConnectImportPath {
+		name = pkg.Name
+	}
+	ctx.Imports.Add(ctx.ConnectImportPath, name)
+
+	registerContent, err := renderGoFile(TEMPLATE_REGISTER, ctx)
+	if err != nil {
+		return fmt.Errorf("failed to render register template: %w", err)
+	}
+
+	out.write(ctx.RegisterPath, registerContent)
+	return nil
 }
 
 // generateStructFileIfNeeded generates the struct file only if it doesn't exist
-func generateStructFileIfNeeded(ctx Context, opts *Options) ([]*pluginpb.CodeGeneratorResponse_File, error) {
-	fullStructPath := constructFullPath(opts.Out, ctx.StructPath)
-	if !fileExists(fullStructPath) {
-		structContent, err := renderTemplate(TEMPLATE_STRUCT, ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to render struct template: %w", err)
-		}
+func generateStructFileIfNeeded(ctx Context, out *outputSet) error {
+	if out.exists(ctx.StructPath) {
+		return nil
+	}
 
-		return []*pluginpb.CodeGeneratorResponse_File{
-			{
-				Name:    &ctx.StructPath,
-				Content: &structContent,
-			},
-		}, nil
+	structContent, err := renderGoFile(TEMPLATE_STRUCT, ctx)
+	if err != nil {
+		return fmt.Errorf("failed to render struct template: %w", err)
 	}
-	return nil, nil
+
+	out.write(ctx.StructPath, structContent)
+	return nil
 }
 
 // generatePerMethodFiles generates individual method files for per-method mode
-func generatePerMethodFiles(fileDesc *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto, ctx Context, opts *Options) ([]*pluginpb.CodeGeneratorResponse_File, error) {
-	var files []*pluginpb.CodeGeneratorResponse_File
-
+func generatePerMethodFiles(svc *descriptorpb.ServiceDescriptorProto, ctx Context, idx *typeIndex, pkg *packageIndex, out *outputSet) error {
 	for _, method := range svc.GetMethod() {
-		methodCtx := ctx
-		methodCtx.Method = &MethodContext{
-			Name:   method.GetName(),
-			Input:  convertProtoTypeToGo(method.GetInputType(), fileDesc),
-			Output: convertProtoTypeToGo(method.GetOutputType(), fileDesc),
+		// Skip methods already implemented in any file of the package
+		if pkg.hasMethod(ctx.StructName, method.GetName()) || ctx.skip[method.GetName()] {
+			continue
 		}
 
-		methodFileBase := fmt.Sprintf("%s_%s",
-			toSnakeCase(svc.GetName()), toSnakeCase(method.GetName()))
-		methodPath := filepath.Join(ctx.Dir, methodFileBase+".go")
+		methodCtx := ctx
+		methodCtx.Method = newMethodContext(method, idx, ctx.Imports)
+
+		methodPath := filepath.Join(ctx.Dir, methodFileName(svc.GetName(), method.GetName()))
 		methodCtx.MethodPath = methodPath
 
-		// Only generate if method is not already implemented
-		fullMethodPath := constructFullPath(opts.Out, methodPath)
-		if !fileExists(fullMethodPath) {
-			methodContent, err := renderTemplate(TEMPLATE_METHOD, methodCtx)
-			if err != nil {
-				return nil, fmt.Errorf("failed to render method template: %w", err)
-			}
+		// Never overwrite an existing file, even if it no longer holds the method
+		if out.exists(methodPath) {
+			continue
+		}
 
-			files = append(files, &pluginpb.CodeGeneratorResponse_File{
-				Name:    &methodPath,
-				Content: &methodContent,
-			})
+		methodContent, err := renderGoFile(TEMPLATE_METHOD, methodCtx)
+		if err != nil {
+			return fmt.Errorf("failed to render method template: %w", err)
 		}
+		out.write(methodPath, methodContent)
+		pkg.add(ctx.StructName, method.GetName(), methodLocation{File: filepath.Base(methodPath), RPC: true, Generated: true})
 	}
 
-	return files, nil
+	return nil
 }
 
 // generatePerServiceStructFile handles per-service mode by building the complete struct file with all methods
-func generatePerServiceStructFile(fileDesc *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto, ctx Context, opts *Options) ([]*pluginpb.CodeGeneratorResponse_File, error) {
-	fullStructPath := constructFullPath(opts.Out, ctx.StructPath)
-
-	// Check if file already exists on disk (from previous runs)
-	var existingContent string
-	if fileExists(fullStructPath) {
-		// Read existing file content
-		content, err := os.ReadFile(fullStructPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read existing struct file: %w", err)
-		}
-		existingContent = string(content)
-	} else {
-		// Generate base struct content
-		structContent, err := renderTemplate(TEMPLATE_STRUCT, ctx)
+func generatePerServiceStructFile(svc *descriptorpb.ServiceDescriptorProto, ctx Context, idx *typeIndex, pkg *packageIndex, out *outputSet) error {
+	// Start from the existing file (from previous runs) if there is one
+	existingContent, exists, err := out.read(ctx.StructPath)
+	if err != nil {
+		return fmt.Errorf("failed to read existing struct file: %w", err)
+	}
+	if !exists {
+		// Generate base struct content; imports are pruned once methods are added
+		existingContent, err = renderTemplate(TEMPLATE_STRUCT, ctx)
 		if err != nil {
-			return nil, fmt.Errorf("failed to render struct template: %w", err)
+			return fmt.Errorf("failed to render struct template: %w", err)
 		}
-		existingContent = structContent
 	}
 
-	// Build new methods to append
-	var newMethods []string
+	origin := fmt.Sprintf("merged file %s for service %s", ctx.StructPath, ctx.Service.Name)
+	file, err := parser.ParseFile(token.NewFileSet(), ctx.StructPath, existingContent, parser.ParseComments)
+	if err != nil {
+		return invalidGoError(existingContent, origin, 0, err)
+	}
+
+	// New methods reuse the local names the file already imports packages by
+	fileImports := importsForFile(file, ctx.Imports)
+	existing := methodDecls(file, ctx.StructName)
+
+	// Render stubs for the RPCs the file does not implement yet
+	var rpcOrder []string
+	var stubs []methodStub
 	for _, method := range svc.GetMethod() {
-		if !FuncExists(fullStructPath, ctx.StructName, method.GetName()) {
-			methodCtx := ctx
-			methodCtx.Method = &MethodContext{
-				Name:   method.GetName(),
-				Input:  convertProtoTypeToGo(method.GetInputType(), fileDesc),
-				Output: convertProtoTypeToGo(method.GetOutputType(), fileDesc),
-			}
+		rpcOrder = append(rpcOrder, method.GetName())
+		if _, ok := existing[method.GetName()]; ok || pkg.hasMethod(ctx.StructName, method.GetName()) || ctx.skip[method.GetName()] {
+			continue
+		}
 
-			methodContent, err := renderTemplate(TEMPLATE_METHOD_ONLY, methodCtx)
-			if err != nil {
-				return nil, fmt.Errorf("failed to render method template: %w", err)
-			}
-			newMethods = append(newMethods, methodContent)
+		methodCtx := ctx
+		methodCtx.Imports = fileImports
+		methodCtx.Method = newMethodContext(method, idx, fileImports)
+
+		methodContent, err := renderTemplate(TEMPLATE_METHOD_ONLY, methodCtx)
+		if err != nil {
+			return fmt.Errorf("failed to render method template: %w", err)
+		}
+		if err := validateGoDecls(methodContent, templateOrigin(TEMPLATE_METHOD_ONLY, methodCtx)); err != nil {
+			return err
 		}
+		stubs = append(stubs, methodStub{Name: method.GetName(), Content: methodContent})
+		pkg.add(ctx.StructName, method.GetName(), methodLocation{File: filepath.Base(ctx.StructPath), RPC: true, Generated: true})
 	}
 
-	// Combine existing content with new methods
-	var finalContent string
-	if len(newMethods) > 0 {
-		finalContent = existingContent + "\n" + strings.Join(newMethods, "\n\n")
-	} else {
-		finalContent = existingContent
+	finalContent, err := mergeMethods(existingContent, ctx.StructName, rpcOrder, stubs, fileImports)
+	if err != nil {
+		return fmt.Errorf("%s: %w", origin, err)
 	}
 
-	return []*pluginpb.CodeGeneratorResponse_File{
-		{
-			Name:    &ctx.StructPath,
-			Content: &finalContent,
-		},
-	}, nil
+	// Drop imports whose last user was moved away or never generated
+	finalContent, err = finalizeGoFile(finalContent, fileImports, origin)
+	if err != nil {
+		return err
+	}
+
+	out.write(ctx.StructPath, finalContent)
+	return nil
 }
 
-// Context holds template data for code generation
+// Context is the data every template receives. Version tells custom
+// templates which fields to expect; see templateDataVersion.
 type Context struct {
+	Version      int
+	File         *FileContext // proto file declaring the service
 	PackageName  string
 	StructName   string
 	Receiver     string // e.g. "h"
@@ -227,64 +335,250 @@ type Context struct {
 	Method       *MethodContext
 	ManifestPath string
 	StructPath   string
+	StatePath    string
+	RegisterPath string
 	MethodPath   string
 	Dir          string
 	Mode         string
-	ProtoImport  string
+	Imports      *Imports // packages referenced by the service's files
+	ImportPath   string   // Go import path of the handler package, if configured
+	TemplateDir  string   // directory of templates overriding the embedded ones
+
+	// ConnectImportPath is the Go import path of the package connect-go
+	// generates for the service, e.g. "example.com/gen/test/v1/testv1connect",
+	// or "" when the proto file has no Go import path
+	ConnectImportPath string
+
+	types *typeIndex      // resolves proto types for template functions
+	skip  map[string]bool // RPCs that get no stub, see skipDeprecatedRPCs
 }
 
+// ServiceContext describes the service a template renders
 type ServiceContext struct {
-	Name    string
-	Methods []*MethodContext
+	Name       string
+	FullName   string // e.g. "test.v1.TestService"
+	Methods    []*MethodContext
+	Skipped    []*MethodContext // deprecated RPCs left without a stub, see deprecated_rpcs
+	Comments   string
+	Location   SourceLocation
+	Options    map[string]string
+	Deprecated bool
 }
 
+// MethodContext describes an RPC
 type MethodContext struct {
-	Name   string
-	Input  string
-	Output string
+	Name            string
+	FullName        string // e.g. "test.v1.TestService.Echo"
+	Procedure       string // connect procedure, e.g. "/test.v1.TestService/Echo"
+	Input           string // Go type, e.g. "testv1.EchoRequest"
+	Output          string
+	InputType       string // proto full name, e.g. "test.v1.EchoRequest"
+	OutputType      string
+	Request         *MessageContext // nil when the request does not carry the message
+	Response        *MessageContext
+	StreamType      string // "unary", "client_streaming", "server_streaming" or "bidi_streaming"
+	ClientStreaming bool
+	ServerStreaming bool
+	Comments        string
+	Location        SourceLocation
+	Options         map[string]string
+	Deprecated      bool   // set when the RPC or its service is deprecated
+	Idempotency     string // "NO_SIDE_EFFECTS", "IDEMPOTENT" or "IDEMPOTENCY_UNKNOWN"
+
+	// DeprecatedService is the full name of the service when the RPC is
+	// deprecated only through it, e.g. "test.v1.TestService"
+	DeprecatedService string
+}
+
+// newMethodContext creates a template context for a single RPC, qualifying
+// its message types with the local names registered in imports
+func newMethodContext(method *descriptorpb.MethodDescriptorProto, idx *typeIndex, imports *Imports) *MethodContext {
+	fullName := idx.decls[method].fullName
+	return &MethodContext{
+		Name:            method.GetName(),
+		FullName:        fullName,
+		Procedure:       procedureOf(fullName),
+		Input:           imports.Qualify(resolveGoType(method.GetInputType(), idx)),
+		Output:          imports.Qualify(resolveGoType(method.GetOutputType(), idx)),
+		InputType:       strings.TrimPrefix(method.GetInputType(), "."),
+		OutputType:      strings.TrimPrefix(method.GetOutputType(), "."),
+		Request:         idx.message(method.GetInputType()),
+		Response:        idx.message(method.GetOutputType()),
+		StreamType:      streamTypeOf(method),
+		ClientStreaming: method.GetClientStreaming(),
+		ServerStreaming: method.GetServerStreaming(),
+		Comments:        idx.comments(method),
+		Location:        idx.location(method),
+		Options:         optionsOf(method.GetOptions()),
+		Deprecated:      idx.deprecated(method),
+		Idempotency:     method.GetOptions().GetIdempotencyLevel().String(),
+
+		DeprecatedService: idx.deprecatedService(method),
+	}
+}
+
+// procedureOf returns the connect procedure path of an RPC by proto full
+// name, e.g. "/test.v1.TestService/Echo"
+func procedureOf(fullName string) string {
+	dot := strings.LastIndex(fullName, ".")
+	if dot == -1 {
+		return ""
+	}
+	return "/" + fullName[:dot] + "/" + fullName[dot+1:]
+}
+
+// streamTypeOf reports the connect stream kind of an RPC
+func streamTypeOf(method *descriptorpb.MethodDescriptorProto) string {
+	switch {
+	case method.GetClientStreaming() && method.GetServerStreaming():
+		return streamBidi
+	case method.GetClientStreaming():
+		return streamClient
+	case method.GetServerStreaming():
+		return streamServer
+	default:
+		return streamUnary
+	}
 }
 
 // buildContext creates a template context for a service
-func buildContext(fileDesc *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto, opts *Options) Context {
+func buildContext(fileDesc *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto, idx *typeIndex, opts *Options) (Context, error) {
 	serviceName := svc.GetName()
 	structName := serviceName + "Handler"
 
 	// Build output directory
-	dir := ""
-	if opts.DirPattern != "" {
-		dir = expandPlaceholders(opts.DirPattern, fileDesc, svc)
+	dir, err := expandPattern(opts.DirPattern, fileDesc, svc, idx, opts)
+	if err != nil {
+		return Context{}, err
+	}
+
+	packageName, importPath, err := resolveHandlerPackage(dir, fileDesc, svc, idx, opts)
+	if err != nil {
+		return Context{}, err
 	}
 
-	manifestPath := filepath.Join(dir, toSnakeCase(serviceName)+opts.ImplSuffix+".gen.go")
-	structPath := filepath.Join(dir, toSnakeCase(serviceName)+opts.ImplSuffix+".go")
+	base := filepath.Join(dir, handlerFileBase(serviceName, opts))
 
-	// Build method contexts
+	// Only the register file needs the connect-go package
+	connectPath, err := connectImportPath(fileDesc.GetName(), idx.goPackageOf(fileDesc), opts.ConnectPackageSuffix)
+	if err != nil && opts.Register {
+		return Context{}, err
+	}
+
+	// Build method contexts, registering the packages they reference
+	imports := newImports()
 	var methods []*MethodContext
 	for _, method := range svc.GetMethod() {
-		methods = append(methods, &MethodContext{
-			Name:   method.GetName(),
-			Input:  convertProtoTypeToGo(method.GetInputType(), fileDesc),
-			Output: convertProtoTypeToGo(method.GetOutputType(), fileDesc),
-		})
+		methods = append(methods, newMethodContext(method, idx, imports))
 	}
 
-	// Extract proto import path from go_package option
-	protoImport := extractGoPackageImport(fileDesc.GetOptions().GetGoPackage())
-
 	return Context{
-		PackageName: generalizePackageName(fileDesc.GetPackage()),
+		Version:     templateDataVersion,
+		File:        idx.fileContext(fileDesc),
+		PackageName: packageName,
 		StructName:  structName,
 		Receiver:    strings.ToLower(structName[:1]), // e.g. "h" for "Handler"
 		Service: &ServiceContext{
-			Name:    serviceName,
-			Methods: methods,
+			Name:       serviceName,
+			FullName:   qualifyProtoName(fileDesc.GetPackage(), serviceName),
+			Methods:    methods,
+			Comments:   idx.comments(svc),
+			Location:   idx.location(svc),
+			Options:    optionsOf(svc.GetOptions()),
+			Deprecated: svc.GetOptions().GetDeprecated(),
 		},
-		ManifestPath: manifestPath,
-		StructPath:   structPath,
+		ManifestPath: base + ".gen.go",
+		StructPath:   base + ".go",
+		StatePath:    base + ".state.json",
+		RegisterPath: base + "_register.gen.go",
 		Dir:          dir,
 		Mode:         opts.Mode,
-		ProtoImport:  protoImport,
+		Imports:      imports,
+		ImportPath:   importPath,
+
+		ConnectImportPath: connectPath,
+		TemplateDir:       opts.TemplateDir,
+		types:             idx,
+	}, nil
+}
+
+// connectImportPath returns the import path of the package connect-go
+// generates for the services of protoFile, whose Go package is pkg: a
+// subpackage named after it plus suffix, or the package itself when suffix
+// is empty. It fails when the Go import path of protoFile is unknown.
+func connectImportPath(protoFile string, pkg goPackage, suffix string) (string, error) {
+	if pkg.ImportPath == "" {
+		return "", fmt.Errorf("%s: unable to determine the connect-go package without a Go import path; set go_package or pass M%s=<import path>",
+			protoFile, protoFile)
+	}
+	if suffix == "" {
+		return pkg.ImportPath, nil
 	}
+	return pkg.ImportPath + "/" + pkg.Name + suffix, nil
+}
+
+// resolveHandlerPackage determines the Go package name and import path of the
+// handler package in dir. A package clause already present in dir always
+// wins so that new files never split the directory into two packages; then
+// handler_package, the handler_go_package name, and finally the proto package.
+func resolveHandlerPackage(dir string, fileDesc *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto, idx *typeIndex, opts *Options) (string, string, error) {
+	var packageName, importPath string
+
+	if opts.HandlerGoPackage != "" {
+		goPackage, err := expandPattern(opts.HandlerGoPackage, fileDesc, svc, idx, opts)
+		if err != nil {
+			return "", "", err
+		}
+		importPath = extractGoPackageImport(goPackage)
+		packageName = extractGoPackageName(goPackage)
+	}
+
+	if opts.HandlerPackage != "" {
+		name, err := expandPattern(opts.HandlerPackage, fileDesc, svc, idx, opts)
+		if err != nil {
+			return "", "", err
+		}
+		packageName = cleanPackageName(name)
+	}
+
+	if packageName == "" {
+		packageName = generalizePackageName(fileDesc.GetPackage())
+	}
+
+	if existing := detectPackageName(constructFullPath(opts.Out, dir)); existing != "" {
+		if existing != packageName && (opts.HandlerPackage != "" || opts.HandlerGoPackage != "") {
+			warnf("%s: keeping existing package %q instead of configured %q", filepath.Join(opts.Out, dir), existing, packageName)
+		}
+		packageName = existing
+	}
+
+	return packageName, importPath, nil
+}
+
+// expandPattern expands the directory placeholders in pattern, including
+// those that need the proto file's resolved Go package
+func expandPattern(pattern string, fileDesc *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto, idx *typeIndex, opts *Options) (string, error) {
+	if pattern == "" {
+		return "", nil
+	}
+	result := expandPlaceholders(pattern, fileDesc, svc)
+
+	if !strings.Contains(result, "{go_package_") {
+		return result, nil
+	}
+	goPkg := idx.goPackageOf(fileDesc)
+	result = strings.ReplaceAll(result, "{go_package_name}", goPkg.Name)
+
+	// {go_package_path} needs the resolved Go import path of the proto file
+	if strings.Contains(result, "{go_package_path}") {
+		goPackagePath, err := stripModulePrefix(goPkg.ImportPath, opts.Module)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", fileDesc.GetName(), err)
+		}
+		result = strings.ReplaceAll(result, "{go_package_path}", goPackagePath)
+	}
+
+	return result, nil
 }
 
 // generalizePackageName converts a package name to a more Go-friendly format
@@ -323,6 +617,18 @@ func expandPlaceholders(pattern string, fileDesc *descriptorpb.FileDescriptorPro
 	return result
 }
 
+// handlerFileBase returns the file name, without extension, of the files
+// generated for a service, e.g. "test_service_handler"
+func handlerFileBase(serviceName string, opts *Options) string {
+	return toSnakeCase(serviceName) + opts.ImplSuffix
+}
+
+// methodFileName returns the name of the per-method file of an RPC, e.g.
+// "test_service_echo.go"
+func methodFileName(serviceName, methodName string) string {
+	return toSnakeCase(serviceName) + "_" + toSnakeCase(methodName) + ".go"
+}
+
 // toSnakeCase converts CamelCase to snake_case
 func toSnakeCase(s string) string {
 	var result strings.Builder
@@ -340,34 +646,41 @@ func toSnakeCase(s string) string {
 }
 
 // convertProtoTypeToGo converts a protobuf type name to Go type name
-func convertProtoTypeToGo(protoType string, fileDesc *descriptorpb.FileDescriptorProto) string {
+func convertProtoTypeToGo(protoType string, idx *typeIndex) string {
+	return resolveGoType(protoType, idx).String()
+}
+
+// resolveGoType resolves a fully-qualified protobuf type name to the Go type
+// generated for it, using the go_package of the file that declares it
+func resolveGoType(protoType string, idx *typeIndex) goType {
 	// Remove leading dot if present
 	protoType = strings.TrimPrefix(protoType, ".")
 
 	// Split the type name to get package and type
 	parts := strings.Split(protoType, ".")
 	if len(parts) < 2 {
-		return protoType // fallback to original if can't parse
+		return goType{Name: protoType} // fallback to original if can't parse
+	}
+
+	// Look up the file declaring this type and use its go_package
+	if t, ok := idx.lookup(protoType); ok {
+		goPkg := idx.goPackageOf(t.file)
+		return goType{
+			ImportPath:  goPkg.ImportPath,
+			PackageName: goPkg.Name,
+			Name:        t.goName,
+		}
 	}
 
 	// Extract the message name (last part)
 	messageName := parts[len(parts)-1]
 
-	// Extract package parts (all but last)
+	// Unknown package: fall back to the underscore approach
 	protoPackage := strings.Join(parts[:len(parts)-1], ".")
-
-	// Check if this type is from the current file
-	if protoPackage == fileDesc.GetPackage() {
-		// Extract Go package name from go_package option
-		goPackageOption := fileDesc.GetOptions().GetGoPackage()
-		goPackage := extractGoPackageName(goPackageOption)
-		return goPackage + "." + messageName
+	return goType{
+		PackageName: strings.ReplaceAll(protoPackage, ".", "_"),
+		Name:        messageName,
 	}
-
-	// For external packages, fall back to the underscore approach
-	// This would need to be enhanced to look up the actual go_package for external types
-	goPackage := strings.ReplaceAll(protoPackage, ".", "_")
-	return goPackage + "." + messageName
 }
 
 // extractGoPackageName extracts the package name from go_package option
@@ -385,7 +698,7 @@ func extractGoPackageName(goPackage string) string {
 
 	// If no semicolon, use the last part of the import path
 	parts := strings.Split(goPackage, "/")
-	return parts[len(parts)-1]
+	return cleanPackageName(parts[len(parts)-1])
 }
 
 // extractGoPackageImport extracts the import path from go_package option
@@ -403,12 +716,34 @@ func extractGoPackageImport(goPackage string) string {
 	return goPackage
 }
 
+// stripModulePrefix removes the module= prefix from a Go import path, as
+// protoc-gen-go does when placing generated files
+// Example: ("example.com/gen/test/v1", "example.com/gen") -> "test/v1"
+func stripModulePrefix(importPath, module string) (string, error) {
+	if importPath == "" {
+		return "", fmt.Errorf("unable to determine Go import path; set go_package or pass an M option")
+	}
+	if module == "" {
+		return importPath, nil
+	}
+	if importPath == module {
+		return ".", nil
+	}
+	if !strings.HasPrefix(importPath, module+"/") {
+		return "", fmt.Errorf("import path %q does not match module prefix %q", importPath, module)
+	}
+	return strings.TrimPrefix(importPath, module+"/"), nil
+}
+
 // constructFullPath builds the full file path including output directory
 func constructFullPath(outputDir, relativePath string) string {
 	if outputDir == "" {
 		// If no output directory specified, use relative path as-is
 		return relativePath
 	}
+	if filepath.IsAbs(outputDir) {
+		return filepath.Join(outputDir, relativePath)
+	}
 
 	// Get current working directory
 	cwd, err := os.Getwd()
@@ -1,6 +1,11 @@
 package generator
 
 import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"google.golang.org/protobuf/types/descriptorpb"
@@ -131,6 +136,461 @@ func TestGenerate(t *testing.T) {
 	}
 }
 
+// streamingRequest asks for the handler of a StreamService with an RPC of
+// each stream type: Unary, Download (server), Upload (client) and Chat (bidi)
+func streamingRequest(parameter string) *pluginpb.CodeGeneratorRequest {
+	pkg := "test.v1"
+	serviceName := "StreamService"
+	fileName := "test/stream_service.proto"
+	goPackage := "example.com/gen/test/v1;testv1"
+
+	newMethod := func(name string, clientStreaming, serverStreaming bool) *descriptorpb.MethodDescriptorProto {
+		input := ".test.v1." + name + "Request"
+		output := ".test.v1." + name + "Response"
+		return &descriptorpb.MethodDescriptorProto{
+			Name:            &name,
+			InputType:       &input,
+			OutputType:      &output,
+			ClientStreaming: &clientStreaming,
+			ServerStreaming: &serverStreaming,
+		}
+	}
+
+	return &pluginpb.CodeGeneratorRequest{
+		Parameter:      &parameter,
+		FileToGenerate: []string{fileName},
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			{
+				Name:    &fileName,
+				Package: &pkg,
+				Options: &descriptorpb.FileOptions{GoPackage: &goPackage},
+				Service: []*descriptorpb.ServiceDescriptorProto{
+					{
+						Name: &serviceName,
+						Method: []*descriptorpb.MethodDescriptorProto{
+							newMethod("Unary", false, false),
+							newMethod("Download", false, true),
+							newMethod("Upload", true, false),
+							newMethod("Chat", true, true),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestGenerateStreamingSignatures(t *testing.T) {
+	resp, err := Generate(streamingRequest("out=gen"))
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+
+	var manifest, structFile string
+	for _, file := range resp.File {
+		switch file.GetName() {
+		case "stream_service_handler.gen.go":
+			manifest = file.GetContent()
+		case "stream_service_handler.go":
+			structFile = file.GetContent()
+		}
+	}
+
+	manifestWant := []string{
+		"Unary(context.Context, *connect.Request[testv1.UnaryRequest]) (*connect.Response[testv1.UnaryResponse], error)",
+		"Download(context.Context, *connect.Request[testv1.DownloadRequest], *connect.ServerStream[testv1.DownloadResponse]) error",
+		"Upload(context.Context, *connect.ClientStream[testv1.UploadRequest]) (*connect.Response[testv1.UploadResponse], error)",
+		"Chat(context.Context, *connect.BidiStream[testv1.ChatRequest, testv1.ChatResponse]) error",
+	}
+	for _, want := range manifestWant {
+		if !contains(manifest, want) {
+			t.Errorf("manifest missing %q\n%s", want, manifest)
+		}
+	}
+
+	structWant := []string{
+		"req *connect.Request[testv1.UnaryRequest],\n) (*connect.Response[testv1.UnaryResponse], error) {",
+		"stream *connect.ServerStream[testv1.DownloadResponse],\n) error {",
+		"stream *connect.ClientStream[testv1.UploadRequest],\n) (*connect.Response[testv1.UploadResponse], error) {",
+		"stream *connect.BidiStream[testv1.ChatRequest, testv1.ChatResponse],\n) error {",
+	}
+	for _, want := range structWant {
+		if !contains(structFile, want) {
+			t.Errorf("struct file missing %q\n%s", want, structFile)
+		}
+	}
+}
+
+func TestGenerateStreamingStubBodies(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		expected string
+	}{
+		{
+			name: "unary",
+			file: "stream_service_unary.go",
+			expected: `
+) (*connect.Response[testv1.UnaryResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented,
+		errors.New("Unary not implemented"))
+}
+`,
+		},
+		{
+			name: "server streaming",
+			file: "stream_service_download.go",
+			expected: `
+) error {
+	// Send each response on the stream:
+	//
+	//	if err := stream.Send(&testv1.DownloadResponse{}); err != nil {
+	//		return err
+	//	}
+	return connect.NewError(connect.CodeUnimplemented,
+		errors.New("Download not implemented"))
+}
+`,
+		},
+		{
+			name: "client streaming",
+			file: "stream_service_upload.go",
+			expected: `
+) (*connect.Response[testv1.UploadResponse], error) {
+	for stream.Receive() {
+		// Handle stream.Msg()
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	return nil, connect.NewError(connect.CodeUnimplemented,
+		errors.New("Upload not implemented"))
+}
+`,
+		},
+		{
+			name: "bidi streaming",
+			file: "stream_service_chat.go",
+			expected: `
+) error {
+	for {
+		msg, err := stream.Receive()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// Handle msg and reply with stream.Send
+		_ = msg
+	}
+	return connect.NewError(connect.CodeUnimplemented,
+		errors.New("Chat not implemented"))
+}
+`,
+		},
+	}
+
+	resp, err := Generate(streamingRequest("out=gen,mode=per_method"))
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	files := make(map[string]string)
+	for _, file := range resp.File {
+		files[file.GetName()] = file.GetContent()
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, ok := files[tt.file]
+			if !ok {
+				t.Fatalf("expected file %s to be generated", tt.file)
+			}
+			if !strings.HasSuffix(content, tt.expected) {
+				t.Errorf("%s should end with%s\ngot\n%s", tt.file, tt.expected, content)
+			}
+			if _, err := parser.ParseFile(token.NewFileSet(), tt.file, content, 0); err != nil {
+				t.Errorf("%s does not parse: %v", tt.file, err)
+			}
+		})
+	}
+	if !contains(files["stream_service_chat.go"], "\t\"io\"\n") {
+		t.Errorf("bidi stub should import io\n%s", files["stream_service_chat.go"])
+	}
+}
+
+func TestGenerateExternalMessageTypes(t *testing.T) {
+	serviceName := "TestService"
+	methodName := "Ping"
+	inputType := ".google.protobuf.Empty"
+	outputType := ".common.v1.Status"
+	parameter := "out=gen"
+
+	protoFiles := testProtoFiles()
+	protoFiles[2].Service = []*descriptorpb.ServiceDescriptorProto{
+		{
+			Name: &serviceName,
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{
+					Name:       &methodName,
+					InputType:  &inputType,
+					OutputType: &outputType,
+				},
+			},
+		},
+	}
+
+	req := &pluginpb.CodeGeneratorRequest{
+		Parameter:      &parameter,
+		FileToGenerate: []string{protoFiles[2].GetName()},
+		ProtoFile:      protoFiles,
+	}
+
+	resp, err := Generate(req)
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+
+	for _, file := range resp.File {
+		if file.GetName() != "test_service_handler.gen.go" {
+			continue
+		}
+		content := file.GetContent()
+		for _, want := range []string{
+			`"google.golang.org/protobuf/types/known/emptypb"`,
+			`"example.com/gen/common/v1"`,
+			"Ping(context.Context, *connect.Request[emptypb.Empty]) (*connect.Response[commonv1.Status], error)",
+		} {
+			if !contains(content, want) {
+				t.Errorf("manifest missing %q\n%s", want, content)
+			}
+		}
+		if contains(content, `"example.com/gen/test/v1"`) {
+			t.Errorf("manifest should not import the unused service package\n%s", content)
+		}
+		return
+	}
+	t.Fatal("Expected manifest file not generated")
+}
+
+func TestGenerateImportPathMappings(t *testing.T) {
+	fileName := "test/v1/test_service.proto"
+	pkg := "test.v1"
+	serviceName := "TestService"
+	methodName := "Echo"
+	inputType := ".test.v1.EchoRequest"
+	outputType := ".test.v1.EchoResponse"
+	parameter := "out=gen,Mtest/v1/test_service.proto=example.com/gen/test/v1;testv1," +
+		"module=example.com/gen,dir_pattern=handlers/{go_package_path}"
+
+	req := &pluginpb.CodeGeneratorRequest{
+		Parameter:      &parameter,
+		FileToGenerate: []string{fileName},
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			{
+				Name:    &fileName,
+				Package: &pkg,
+				Service: []*descriptorpb.ServiceDescriptorProto{
+					{
+						Name: &serviceName,
+						Method: []*descriptorpb.MethodDescriptorProto{
+							{Name: &methodName, InputType: &inputType, OutputType: &outputType},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	resp, err := Generate(req)
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+
+	var manifest string
+	for _, file := range resp.File {
+		if file.GetName() == "handlers/test/v1/test_service_handler.gen.go" {
+			manifest = file.GetContent()
+		}
+	}
+	if manifest == "" {
+		t.Fatal("Expected manifest under handlers/test/v1")
+	}
+	for _, want := range []string{
+		`testv1 "example.com/gen/test/v1"`,
+		"*connect.Request[testv1.EchoRequest]",
+	} {
+		if !contains(manifest, want) {
+			t.Errorf("manifest missing %q\n%s", want, manifest)
+		}
+	}
+
+	// An import path outside the module prefix cannot be placed
+	parameter = "out=gen,Mtest/v1/test_service.proto=other.com/test/v1," +
+		"module=example.com/gen,dir_pattern={go_package_path}"
+	if _, err := Generate(req); err == nil {
+		t.Error("Generate() expected error for import path outside module, got nil")
+	}
+}
+
+func TestGenerateHandlerPackage(t *testing.T) {
+	fileName := "test/v1/test_service.proto"
+	pkg := "test.v1"
+	goPackage := "example.com/gen/test/v1;testv1"
+	serviceName := "TestService"
+
+	newRequest := func(parameter string) *pluginpb.CodeGeneratorRequest {
+		return &pluginpb.CodeGeneratorRequest{
+			Parameter:      &parameter,
+			FileToGenerate: []string{fileName},
+			ProtoFile: []*descriptorpb.FileDescriptorProto{
+				{
+					Name:    &fileName,
+					Package: &pkg,
+					Options: &descriptorpb.FileOptions{GoPackage: &goPackage},
+					Service: []*descriptorpb.ServiceDescriptorProto{{Name: &serviceName}},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		parameter string
+		existing  string // package clause of a file already in the target directory
+		expected  string
+	}{
+		{"default", "out=gen", "", "package test_v1"},
+		{"handler_package", "out=gen,handler_package={service_snake}", "", "package test_service"},
+		{"handler_go_package name", "out=gen,handler_go_package=example.com/internal/{package_path};{go_package_name}handler", "", "package testv1handler"},
+		{"handler_go_package path", "out=gen,handler_go_package=example.com/internal/{service_snake}", "", "package test_service"},
+		{"existing package wins", "out=gen,handler_package=handler", "package legacy\n", "package legacy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			if tt.existing != "" {
+				if err := os.MkdirAll("gen", 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join("gen", "existing.go"), []byte(tt.existing), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			resp, err := Generate(newRequest(tt.parameter))
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			for _, file := range resp.File {
+				if filepath.Ext(file.GetName()) != ".go" {
+					continue
+				}
+				if !contains(file.GetContent(), tt.expected+"\n") {
+					t.Errorf("%s missing %q\n%s", file.GetName(), tt.expected, file.GetContent())
+				}
+			}
+		})
+	}
+}
+
+func TestGeneratePerServiceMergesIntoExistingFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll("gen", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	existing := `package test_v1
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	testv1 "example.com/gen/test/v1"
+)
+
+// TestServiceHandler handles TestService RPCs
+type TestServiceHandler struct {
+	greeting string // developer field
+}
+
+// Echo is implemented by hand
+func (t *TestServiceHandler) Echo(
+	ctx context.Context,
+	req *connect.Request[testv1.EchoRequest],
+) (*connect.Response[testv1.EchoRequest], error) {
+	// developer logic stays as is
+	return connect.NewResponse(req.Msg), nil
+}
+`
+	if err := os.WriteFile(filepath.Join("gen", "test_service_handler.go"), []byte(existing), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := Generate(testRequestWithServices("out=gen"))
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+
+	var structFile string
+	for _, file := range resp.File {
+		if file.GetName() == "test_service_handler.go" {
+			structFile = file.GetContent()
+		}
+	}
+
+	for _, want := range []string{
+		existing[strings.Index(existing, "// TestServiceHandler handles"):],
+		"\t\"errors\"\n",
+		"\tcommonv1 \"example.com/gen/common/v1\"\n",
+		"\t\"google.golang.org/protobuf/types/known/emptypb\"\n",
+		"}\n\n// Ping implements the Ping RPC\n",
+	} {
+		if !contains(structFile, want) {
+			t.Errorf("struct file missing %q\n%s", want, structFile)
+		}
+	}
+}
+
+func TestGenerateSkipsMethodsImplementedElsewhere(t *testing.T) {
+	for _, mode := range []string{"per_service", "per_method"} {
+		t.Run(mode, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			writeTestFiles(t, "gen", map[string]string{
+				"test_service_handler.go": "package test_v1\n\ntype TestServiceHandler struct{}\n",
+				"echo_impl.go": `package test_v1
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	testv1 "example.com/gen/test/v1"
+)
+
+func (t *TestServiceHandler) Echo(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {
+	return connect.NewResponse(req.Msg), nil
+}
+`,
+			})
+
+			resp, err := Generate(testRequestWithServices("out=gen,mode=" + mode))
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+
+			for _, file := range resp.File {
+				switch file.GetName() {
+				case "test_service_echo.go", "echo_impl.go":
+					t.Errorf("unexpected file %s", file.GetName())
+				}
+				if contains(file.GetContent(), ") Echo(") {
+					t.Errorf("%s should not contain an Echo stub\n%s", file.GetName(), file.GetContent())
+				}
+			}
+		})
+	}
+}
+
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) &&
 		(s == substr || len(s) > len(substr) &&
@@ -146,3 +606,49 @@ func containsInMiddle(s, substr string) bool {
 	}
 	return false
 }
+
+func TestGenerateCopiesProtoComments(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	req := testRequestWithServices("out=gen,mode=per_method")
+	req = withComment(req, []int32{6, 0}, " TestService tests things.\n")
+	req = withComment(req, []int32{6, 0, 2, 0}, " Echo returns the input message.\n\n It is documented at length.\n renamed_from: OldEcho\n")
+
+	resp, err := Generate(req)
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	files := make(map[string]string)
+	for _, file := range resp.File {
+		files[file.GetName()] = file.GetContent()
+	}
+
+	echoDoc := "// Echo returns the input message.\n" +
+		"//\n" +
+		"// It is documented at length.\n" +
+		"//\n" +
+		"// Proto: test.v1.TestService.Echo (test/v1/test_service.proto)\n"
+	for name, substrings := range map[string][]string{
+		"test_service_handler.gen.go": {
+			"// TestServiceServer defines the interface for TestService service\n//\n" +
+				"// TestService tests things.\n//\n// Proto: test.v1.TestService (test/v1/test_service.proto)\n",
+			strings.ReplaceAll("\t"+echoDoc, "\n/", "\n\t/") + "\tEcho(",
+			// RPCs without comments get the generic summary
+			"\t// Ping implements the Ping RPC\n\t//\n\t// Proto: test.v1.TestService.Ping (test/v1/test_service.proto)\n\tPing(",
+		},
+		"test_service_handler.go": {
+			"// TestServiceHandler handles TestService RPCs\n//\n" +
+				"// TestService tests things.\n//\n// Proto: test.v1.TestService (test/v1/test_service.proto)\ntype TestServiceHandler struct",
+		},
+		"test_service_echo.go": {echoDoc + "func (t *TestServiceHandler) Echo("},
+	} {
+		for _, substr := range substrings {
+			if !strings.Contains(files[name], substr) {
+				t.Errorf("%s should contain %q\n%s", name, substr, files[name])
+			}
+		}
+	}
+	if strings.Contains(files["test_service_echo.go"], "renamed_from") {
+		t.Errorf("directives should not be copied into doc comments\n%s", files["test_service_echo.go"])
+	}
+}
@@ -0,0 +1,284 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"maps"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	importContext = "context"
+	importErrors  = "errors"
+	importConnect = "connectrpc.com/connect"
+)
+
+// reservedImportNames are identifiers templates use that imports must not shadow
+var reservedImportNames = []string{"ctx", "req", "stream"}
+
+// Import is a single Go import with the local name generated code refers to it by
+type Import struct {
+	Path string
+	Name string
+}
+
+// String renders the import spec, adding an alias only when the local name
+// differs from the last element of the import path
+func (i Import) String() string {
+	if i.Name == path.Base(i.Path) {
+		return strconv.Quote(i.Path)
+	}
+	return i.Name + " " + strconv.Quote(i.Path)
+}
+
+// isStd reports whether the import belongs to the standard library
+func (i Import) isStd() bool {
+	first, _, _ := strings.Cut(i.Path, "/")
+	return !strings.Contains(first, ".")
+}
+
+// Imports records the Go packages referenced by the files of a service and
+// gives each one a unique local name
+type Imports struct {
+	byPath   map[string]string // import path -> local name
+	byName   map[string]string // local name -> import path
+	pkgNames map[string]string // import path -> declared package name
+}
+
+// newImports creates an import set with the packages every template uses
+func newImports() *Imports {
+	im := &Imports{
+		byPath:   make(map[string]string),
+		byName:   make(map[string]string),
+		pkgNames: make(map[string]string),
+	}
+	for _, name := range reservedImportNames {
+		im.byName[name] = ""
+	}
+
+	im.Add(importContext, "context")
+	im.Add(importErrors, "errors")
+	im.Add(importConnect, "connect")
+	return im
+}
+
+// Add registers an import and returns the local name to refer to it by.
+// Colliding package names get a numeric suffix, as protoc-gen-go does.
+func (im *Imports) Add(importPath, packageName string) string {
+	if name, ok := im.byPath[importPath]; ok {
+		return name
+	}
+
+	if packageName == "" {
+		packageName = cleanPackageName(path.Base(importPath))
+	}
+	im.pkgNames[importPath] = packageName
+
+	name := packageName
+	for i := 1; im.isTaken(name); i++ {
+		name = packageName + strconv.Itoa(i)
+	}
+
+	im.byPath[importPath] = name
+	im.byName[name] = importPath
+	return name
+}
+
+// clone returns a copy of the import set that can be added to without
+// changing im
+func (im *Imports) clone() *Imports {
+	return &Imports{
+		byPath:   maps.Clone(im.byPath),
+		byName:   maps.Clone(im.byName),
+		pkgNames: maps.Clone(im.pkgNames),
+	}
+}
+
+// size returns the number of imports registered, 0 for a nil set
+func (im *Imports) size() int {
+	if im == nil {
+		return 0
+	}
+	return len(im.byPath)
+}
+
+// Qualify registers the package of t and returns its package-qualified name
+func (im *Imports) Qualify(t goType) string {
+	if t.ImportPath == "" {
+		return t.String()
+	}
+	return im.Add(t.ImportPath, t.PackageName) + "." + t.Name
+}
+
+// Std returns the standard library imports, sorted by path
+func (im *Imports) Std() []Import {
+	return im.list(true)
+}
+
+// Third returns the non-standard library imports, sorted by path
+func (im *Imports) Third() []Import {
+	return im.list(false)
+}
+
+func (im *Imports) list(std bool) []Import {
+	var imports []Import
+	for p, name := range im.byPath {
+		imp := Import{Path: p, Name: name}
+		if imp.isStd() == std {
+			imports = append(imports, imp)
+		}
+	}
+	sort.Slice(imports, func(i, j int) bool {
+		return imports[i].Path < imports[j].Path
+	})
+	return imports
+}
+
+// localName returns the name a file refers to an import by: its alias, or
+// the declared package name when known
+func (im *Imports) localName(spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+
+	importPath, _ := strconv.Unquote(spec.Path.Value)
+	if name, ok := im.pkgNames[importPath]; ok {
+		return name
+	}
+	return cleanPackageName(path.Base(importPath))
+}
+
+func (im *Imports) isTaken(name string) bool {
+	if _, ok := im.byName[name]; ok {
+		return true
+	}
+	return token.IsKeyword(name)
+}
+
+// pruneUnusedImports removes imports managed by im that src never refers to.
+// Imports the generator does not manage are left alone.
+func pruneUnusedImports(src string, im *Imports) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse generated code: %w", err)
+	}
+
+	used := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok {
+				used[ident.Name] = true
+			}
+		}
+		return true
+	})
+
+	lines := strings.SplitAfter(src, "\n")
+	remove := make(map[int]bool) // 1-based line numbers to drop
+
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+
+		kept := 0
+		for _, spec := range gen.Specs {
+			imp := spec.(*ast.ImportSpec)
+			importPath, err := strconv.Unquote(imp.Path.Value)
+			if err != nil {
+				return "", fmt.Errorf("invalid import path %s: %w", imp.Path.Value, err)
+			}
+
+			name, managed := im.byPath[importPath]
+			if name != im.localName(imp) {
+				managed = false
+			}
+			if managed && !used[name] {
+				remove[fset.Position(imp.Pos()).Line] = true
+				continue
+			}
+			kept++
+		}
+
+		if kept == 0 {
+			// Drop the whole declaration, including the parentheses and the
+			// blank line separating it from the next declaration
+			first, last := fset.Position(gen.Pos()).Line, fset.Position(gen.End()).Line
+			for line := first; line <= last; line++ {
+				remove[line] = true
+			}
+			if last < len(lines) && strings.TrimSpace(lines[last]) == "" {
+				remove[last+1] = true
+			}
+			continue
+		}
+
+		// Drop blank lines left behind by removed groups
+		first, last := fset.Position(gen.Lparen).Line, fset.Position(gen.Rparen).Line
+		prevBlank := true
+		for line := first + 1; line < last; line++ {
+			if remove[line] {
+				continue
+			}
+			blank := strings.TrimSpace(lines[line-1]) == ""
+			if blank && (prevBlank || nextKeptLine(lines, remove, line, last) == last) {
+				remove[line] = true
+				continue
+			}
+			prevBlank = blank
+		}
+	}
+
+	if len(remove) == 0 {
+		return src, nil
+	}
+
+	var b strings.Builder
+	for i, line := range lines {
+		if !remove[i+1] {
+			b.WriteString(line)
+		}
+	}
+	return b.String(), nil
+}
+
+// nextKeptLine returns the first line after line that is neither blank nor removed
+func nextKeptLine(lines []string, remove map[int]bool, line, last int) int {
+	for next := line + 1; next < last; next++ {
+		if !remove[next] && strings.TrimSpace(lines[next-1]) != "" {
+			return next
+		}
+	}
+	return last
+}
+
+// cleanPackageName converts a string into a valid Go package name
+func cleanPackageName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z':
+			b.WriteRune(r)
+		case '0' <= r && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+
+	result := b.String()
+	if result == "" || token.IsKeyword(result) {
+		result = "_" + result
+	}
+	return result
+}
@@ -0,0 +1,142 @@
+package generator
+
+import (
+	"testing"
+)
+
+func TestImportsAdd(t *testing.T) {
+	im := newImports()
+
+	tests := []struct {
+		importPath  string
+		packageName string
+		expected    string
+	}{
+		{"example.com/foo/v1", "", "v1"},
+		{"example.com/bar/v1", "", "v11"},
+		{"example.com/foo/v1", "", "v1"},
+		{"example.com/gen/test/v1", "testv1", "testv1"},
+		{"example.com/other/testv1", "", "testv11"},
+		{"example.com/gen/connect/v1", "connect", "connect1"},
+		{"example.com/gen/my-api", "", "my_api"},
+		{"example.com/gen/req", "", "req1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.importPath, func(t *testing.T) {
+			result := im.Add(tt.importPath, tt.packageName)
+			if result != tt.expected {
+				t.Errorf("Add(%v, %v) = %v, want %v", tt.importPath, tt.packageName, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestImportString(t *testing.T) {
+	tests := []struct {
+		imp      Import
+		expected string
+	}{
+		{Import{Path: "context", Name: "context"}, `"context"`},
+		{Import{Path: "connectrpc.com/connect", Name: "connect"}, `"connectrpc.com/connect"`},
+		{Import{Path: "example.com/gen/test/v1", Name: "testv1"}, `testv1 "example.com/gen/test/v1"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if result := tt.imp.String(); result != tt.expected {
+				t.Errorf("String() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPruneUnusedImports(t *testing.T) {
+	im := newImports()
+	im.Add("example.com/gen/test/v1", "testv1")
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "drops unused managed imports",
+			input: `package handler
+
+import (
+	"context"
+	"errors"
+
+	"connectrpc.com/connect"
+	testv1 "example.com/gen/test/v1"
+)
+
+var _ = connect.CodeUnknown
+`,
+			expected: `package handler
+
+import (
+	"connectrpc.com/connect"
+)
+
+var _ = connect.CodeUnknown
+`,
+		},
+		{
+			name: "keeps unmanaged imports",
+			input: `package handler
+
+import (
+	"errors"
+	"log/slog"
+
+	testv1 "example.com/gen/test/v1"
+)
+
+var _ testv1.EchoRequest
+`,
+			expected: `package handler
+
+import (
+	"log/slog"
+
+	testv1 "example.com/gen/test/v1"
+)
+
+var _ testv1.EchoRequest
+`,
+		},
+		{
+			name: "drops empty import block",
+			input: `package handler
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+)
+
+type Server interface {
+}
+`,
+			expected: `package handler
+
+type Server interface {
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := pruneUnusedImports(tt.input, im)
+			if err != nil {
+				t.Fatalf("pruneUnusedImports() failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("pruneUnusedImports() =\n%s\nwant\n%s", result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package generator
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// logOutput receives diagnostics; protoc and buf show the plugin's stderr to the user
+var logOutput io.Writer = os.Stderr
+
+// warnf reports a non-fatal problem found during generation
+func warnf(format string, args ...any) {
+	fmt.Fprintf(logOutput, "protoc-gen-connect-go-handler: warning: "+format+"\n", args...)
+}
+
+// infof reports something generation did that needs no attention, such as a
+// rename it followed, so warnings stay easy to tell apart
+func infof(format string, args ...any) {
+	fmt.Fprintf(logOutput, "protoc-gen-connect-go-handler: "+format+"\n", args...)
+}
@@ -0,0 +1,323 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// methodStub is a rendered method declaration to merge into an existing file
+type methodStub struct {
+	Name    string
+	Content string
+}
+
+// textEdit inserts text at a byte offset of the source being edited,
+// replacing the bytes up to end when end is past offset
+type textEdit struct {
+	offset int
+	end    int
+	text   string
+}
+
+// applyEdits applies edits to src. Edits at the same offset keep their
+// relative order; replaced ranges must not overlap.
+func applyEdits(src string, edits []textEdit) string {
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].offset < edits[j].offset
+	})
+
+	var b strings.Builder
+	last := 0
+	for _, edit := range edits {
+		b.WriteString(src[last:edit.offset])
+		b.WriteString(edit.text)
+		last = max(edit.offset, edit.end)
+	}
+	b.WriteString(src[last:])
+	return b.String()
+}
+
+// importsForFile returns an import set for adding code to an existing file:
+// packages the file already imports keep the local names the file uses, and
+// newly registered packages get names that do not collide with them
+func importsForFile(file *ast.File, known *Imports) *Imports {
+	im := newImports()
+	for importPath, name := range known.pkgNames {
+		im.pkgNames[importPath] = name
+	}
+
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		if _, ok := im.byPath[importPath]; ok {
+			continue
+		}
+
+		name := im.localName(spec)
+		if name == "_" || name == "." || im.isTaken(name) {
+			continue
+		}
+		im.byPath[importPath] = name
+		im.byName[name] = importPath
+	}
+	return im
+}
+
+// mergeMethods inserts method stubs into the Go source src without touching
+// anything already there. Each stub is placed after the closest preceding
+// RPC (in proto order) that src already implements, or before the closest
+// following one, or at the end of the file. Imports the stubs need are added
+// to the existing import declaration.
+func mergeMethods(src, structName string, rpcOrder []string, stubs []methodStub, imports *Imports) (string, error) {
+	if len(stubs) == 0 {
+		return src, nil
+	}
+
+	edit, err := newASTEdit(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse existing file: %w", err)
+	}
+	existing := methodDecls(edit.file, structName)
+
+	position := make(map[string]int, len(rpcOrder))
+	for i, name := range rpcOrder {
+		position[name] = i
+	}
+
+	var needed []Import
+	for _, stub := range stubs {
+		offset, before := insertionPoint(edit.parsed(), edit.file, existing, rpcOrder, position[stub.Name])
+		text := "\n\n" + stub.Content
+		if before {
+			text = stub.Content + "\n\n"
+		}
+		decls, err := edit.insertDecls(offset, text)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse method %s: %w", stub.Name, err)
+		}
+		for _, decl := range decls {
+			needed = append(needed, importsReferencedBy(decl, imports)...)
+		}
+	}
+
+	if err := edit.addImports(missingImports(edit.file, imports, needed)); err != nil {
+		return "", err
+	}
+	return edit.print()
+}
+
+// insertionPoint returns the offset at which to insert the RPC at index
+// rpcIndex and whether the insertion goes before the declaration found there.
+// Insertions keep clear of the comments on the lines of their neighbours.
+func insertionPoint(parsed parsedSource, file *ast.File, existing map[string]*ast.FuncDecl, rpcOrder []string, rpcIndex int) (int, bool) {
+	for i := rpcIndex - 1; i >= 0; i-- {
+		if decl, ok := existing[rpcOrder[i]]; ok {
+			return parsed.lineEnd(decl.End()), false
+		}
+	}
+	for i := rpcIndex + 1; i < len(rpcOrder); i++ {
+		if decl, ok := existing[rpcOrder[i]]; ok {
+			start, _ := declExtent(parsed, decl)
+			return start, true
+		}
+	}
+
+	// Append after the last declaration, before any trailing comments
+	end := file.Name.End()
+	if len(file.Decls) > 0 {
+		end = file.Decls[len(file.Decls)-1].End()
+	}
+	return parsed.lineEnd(end), false
+}
+
+// declExtent returns the byte range of a declaration together with the
+// comments that belong to it: its doc comment, the comments inside it and a
+// comment following it on its last line. Whole lines are covered, through the
+// newline ending the last one, unless other code shares them.
+func declExtent(parsed parsedSource, decl ast.Decl) (int, int) {
+	pos := decl.Pos()
+	if doc := declDoc(decl); doc != nil {
+		pos = doc.Pos()
+	}
+	start := parsed.offset(pos)
+	if lineStart := strings.LastIndexByte(parsed.src[:start], '\n') + 1; strings.TrimSpace(parsed.src[lineStart:start]) == "" {
+		start = lineStart
+	}
+
+	end := parsed.offset(decl.End())
+	rest := parsed.src[end:parsed.lineEnd(decl.End())]
+	if trimmed := strings.TrimSpace(rest); trimmed == "" || strings.HasPrefix(trimmed, "//") || strings.HasPrefix(trimmed, "/*") {
+		end += len(rest)
+		if end < len(parsed.src) {
+			end++
+		}
+	}
+	return start, end
+}
+
+// declDoc returns the doc comment of a declaration
+func declDoc(decl ast.Decl) *ast.CommentGroup {
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		return decl.Doc
+	case *ast.GenDecl:
+		return decl.Doc
+	}
+	return nil
+}
+
+// importsUsedBy returns the imports registered in imports that a fragment of
+// declarations refers to
+func importsUsedBy(fragment string, imports *Imports) ([]Import, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n\n"+fragment, 0)
+	if err != nil {
+		return nil, err
+	}
+	return importsReferencedBy(file, imports), nil
+}
+
+// importsReferencedBy returns the imports registered in imports that node refers to
+func importsReferencedBy(node ast.Node, imports *Imports) []Import {
+	var used []Import
+	seen := make(map[string]bool)
+	ast.Inspect(node, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		ident, ok := sel.X.(*ast.Ident)
+		if !ok || seen[ident.Name] {
+			return true
+		}
+		if importPath := imports.byName[ident.Name]; importPath != "" {
+			seen[ident.Name] = true
+			used = append(used, Import{Path: importPath, Name: ident.Name})
+		}
+		return true
+	})
+	return used
+}
+
+// missingImports returns the needed imports that file does not declare under
+// the expected local name, without duplicates
+func missingImports(file *ast.File, known *Imports, needed []Import) []Import {
+	present := make(map[Import]bool)
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		present[Import{Path: importPath, Name: known.localName(spec)}] = true
+	}
+
+	var missing []Import
+	for _, imp := range needed {
+		if present[imp] {
+			continue
+		}
+		present[imp] = true
+		missing = append(missing, imp)
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i].Path < missing[j].Path
+	})
+	return missing
+}
+
+// importEdits returns edits adding imports to file. Standard library imports
+// join the group holding the file's standard library imports and the others
+// join the last group, so gofmt keeps the grouping intact.
+func importEdits(tokFile *token.File, file *ast.File, src string, imports []Import) []textEdit {
+	if len(imports) == 0 {
+		return nil
+	}
+
+	var std, third []string
+	for _, imp := range imports {
+		if imp.isStd() {
+			std = append(std, "\t"+imp.String()+"\n")
+		} else {
+			third = append(third, "\t"+imp.String()+"\n")
+		}
+	}
+
+	decl := importBlock(file)
+	if decl == nil {
+		block := "import (\n" + strings.Join(std, "")
+		if len(std) > 0 && len(third) > 0 {
+			block += "\n"
+		}
+		block += strings.Join(third, "") + ")\n"
+
+		// Place the new declaration after any existing single-line imports
+		offset := tokFile.Offset(file.Name.End())
+		for _, d := range file.Decls {
+			if gen, ok := d.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+				offset = tokFile.Offset(gen.End())
+			}
+		}
+		return []textEdit{{offset: offset, text: "\n\n" + block}}
+	}
+
+	// lineAfter returns the offset of the line following pos
+	lineAfter := func(pos token.Pos) int {
+		offset := tokFile.Offset(pos)
+		if nl := strings.IndexByte(src[offset:], '\n'); nl != -1 {
+			return offset + nl + 1
+		}
+		return len(src)
+	}
+
+	var lastStd, lastThird ast.Spec
+	for _, spec := range decl.Specs {
+		importPath, _ := strconv.Unquote(spec.(*ast.ImportSpec).Path.Value)
+		if (Import{Path: importPath}).isStd() {
+			lastStd = spec
+		} else {
+			lastThird = spec
+		}
+	}
+
+	var edits []textEdit
+	rparen := tokFile.Offset(tokFile.LineStart(tokFile.Line(decl.Rparen)))
+	if len(std) > 0 {
+		switch {
+		case lastStd != nil:
+			edits = append(edits, textEdit{offset: lineAfter(lastStd.End()), text: strings.Join(std, "")})
+		case len(decl.Specs) > 0:
+			edits = append(edits, textEdit{offset: lineAfter(decl.Lparen), text: strings.Join(std, "") + "\n"})
+		default:
+			edits = append(edits, textEdit{offset: rparen, text: strings.Join(std, "")})
+		}
+	}
+	if len(third) > 0 {
+		switch {
+		case lastThird != nil:
+			edits = append(edits, textEdit{offset: lineAfter(lastThird.End()), text: strings.Join(third, "")})
+		case lastStd != nil || len(std) > 0:
+			edits = append(edits, textEdit{offset: rparen, text: "\n" + strings.Join(third, "")})
+		default:
+			edits = append(edits, textEdit{offset: rparen, text: strings.Join(third, "")})
+		}
+	}
+	return edits
+}
+
+// importBlock returns the last parenthesized import declaration of file, or
+// nil if it has none
+func importBlock(file *ast.File) *ast.GenDecl {
+	var decl *ast.GenDecl
+	for _, d := range file.Decls {
+		if gen, ok := d.(*ast.GenDecl); ok && gen.Tok == token.IMPORT && gen.Lparen.IsValid() {
+			decl = gen
+		}
+	}
+	return decl
+}
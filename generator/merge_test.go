@@ -0,0 +1,350 @@
+package generator
+
+import (
+	"go/ast"
+	"testing"
+)
+
+func TestMergeMethods(t *testing.T) {
+	im := newImports()
+	im.Add("example.com/gen/test/v1", "testv1")
+
+	stub := func(name string) methodStub {
+		return methodStub{
+			Name: name,
+			Content: "// " + name + " implements the " + name + " RPC\n" +
+				"func (h *Handler) " + name + "(ctx context.Context, req *connect.Request[testv1." + name + "Request]) error {\n" +
+				"\treturn errors.New(\"" + name + " not implemented\")\n}",
+		}
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		rpcOrder []string
+		stubs    []methodStub
+		expected string
+	}{
+		{
+			name: "keeps order and adds missing imports",
+			input: `package handler
+
+import (
+	"context"
+	"log/slog" // developer import
+
+	"connectrpc.com/connect"
+)
+
+// Handler is hand-written
+type Handler struct{ log *slog.Logger }
+
+// Echo has a developer comment
+func (h *Handler) Echo(ctx context.Context, req *connect.Request[struct{}]) error {
+	// keep me
+	return nil
+}
+
+// trailing comment
+`,
+			rpcOrder: []string{"First", "Echo", "Last"},
+			stubs:    []methodStub{stub("First"), stub("Last")},
+			expected: `package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog" // developer import
+
+	"connectrpc.com/connect"
+	testv1 "example.com/gen/test/v1"
+)
+
+// Handler is hand-written
+type Handler struct{ log *slog.Logger }
+
+// First implements the First RPC
+func (h *Handler) First(ctx context.Context, req *connect.Request[testv1.FirstRequest]) error {
+	return errors.New("First not implemented")
+}
+
+// Echo has a developer comment
+func (h *Handler) Echo(ctx context.Context, req *connect.Request[struct{}]) error {
+	// keep me
+	return nil
+}
+
+// Last implements the Last RPC
+func (h *Handler) Last(ctx context.Context, req *connect.Request[testv1.LastRequest]) error {
+	return errors.New("Last not implemented")
+}
+
+// trailing comment
+`,
+		},
+		{
+			name: "adds import declaration",
+			input: `package handler
+
+type Handler struct{}
+`,
+			rpcOrder: []string{"Echo"},
+			stubs:    []methodStub{stub("Echo")},
+			expected: `package handler
+
+import (
+	"context"
+	"errors"
+
+	"connectrpc.com/connect"
+	testv1 "example.com/gen/test/v1"
+)
+
+type Handler struct{}
+
+// Echo implements the Echo RPC
+func (h *Handler) Echo(ctx context.Context, req *connect.Request[testv1.EchoRequest]) error {
+	return errors.New("Echo not implemented")
+}
+`,
+		},
+		{
+			name: "adds third-party group",
+			input: `package handler
+
+import "context"
+
+type Handler struct{ ctx context.Context }
+`,
+			rpcOrder: []string{"Echo"},
+			stubs:    []methodStub{stub("Echo")},
+			expected: `package handler
+
+import "context"
+
+import (
+	"errors"
+
+	"connectrpc.com/connect"
+	testv1 "example.com/gen/test/v1"
+)
+
+type Handler struct{ ctx context.Context }
+
+// Echo implements the Echo RPC
+func (h *Handler) Echo(ctx context.Context, req *connect.Request[testv1.EchoRequest]) error {
+	return errors.New("Echo not implemented")
+}
+`,
+		},
+		{
+			name: "keeps comments around the insertion points",
+			input: `package handler
+
+import (
+	// the standard library
+	"context"
+
+	"connectrpc.com/connect" // the framework
+)
+
+type Handler struct{}
+
+// Echo has a developer comment
+func (h *Handler) Echo(ctx context.Context, req *connect.Request[struct{}]) error {
+	return nil
+} // Echo is done
+
+// --- unrelated helpers ---
+
+// Last has a developer comment
+func (h *Handler) Last(ctx context.Context, req *connect.Request[struct{}]) error {
+	/* keep me */
+	return nil
+}
+`,
+			rpcOrder: []string{"Echo", "Middle", "Last"},
+			stubs:    []methodStub{stub("Middle")},
+			expected: `package handler
+
+import (
+	// the standard library
+	"context"
+	"errors"
+
+	"connectrpc.com/connect" // the framework
+	testv1 "example.com/gen/test/v1"
+)
+
+type Handler struct{}
+
+// Echo has a developer comment
+func (h *Handler) Echo(ctx context.Context, req *connect.Request[struct{}]) error {
+	return nil
+} // Echo is done
+
+// Middle implements the Middle RPC
+func (h *Handler) Middle(ctx context.Context, req *connect.Request[testv1.MiddleRequest]) error {
+	return errors.New("Middle not implemented")
+}
+
+// --- unrelated helpers ---
+
+// Last has a developer comment
+func (h *Handler) Last(ctx context.Context, req *connect.Request[struct{}]) error {
+	/* keep me */
+	return nil
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := mergeMethods(tt.input, "Handler", tt.rpcOrder, tt.stubs, im)
+			if err != nil {
+				t.Fatalf("mergeMethods() failed: %v", err)
+			}
+			result, err = formatGo(result, "test")
+			if err != nil {
+				t.Fatalf("merged source does not parse: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("mergeMethods() =\n%s\nwant\n%s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestASTEditReplaceAndRemove(t *testing.T) {
+	src := `package handler
+
+import "context"
+
+// --- RPCs ---
+
+// Echo is an untouched stub
+func (h *Handler) Echo(ctx context.Context) error {
+	// inside Echo
+	return nil
+} // after Echo
+
+// Ping is implemented
+func (h *Handler) Ping(ctx context.Context) error {
+	return nil // inside Ping
+} // after Ping
+
+// --- helpers ---
+
+func helper() {}
+`
+
+	tests := []struct {
+		name     string
+		edit     func(e *astEdit, decls map[string]*ast.FuncDecl) error
+		expected string
+	}{
+		{
+			name: "replace keeps the comments around the declaration",
+			edit: func(e *astEdit, decls map[string]*ast.FuncDecl) error {
+				if err := e.replaceDecl(decls["Echo"], "// Echo is regenerated\nfunc (h *Handler) Echo(ctx context.Context) error {\n\treturn errors.New(\"Echo\")\n}"); err != nil {
+					return err
+				}
+				return e.addImports([]Import{{Path: "errors"}})
+			},
+			expected: `package handler
+
+import "context"
+
+import (
+	"errors"
+)
+
+// --- RPCs ---
+
+// Echo is regenerated
+func (h *Handler) Echo(ctx context.Context) error {
+	return errors.New("Echo")
+} // after Echo
+
+// Ping is implemented
+func (h *Handler) Ping(ctx context.Context) error {
+	return nil // inside Ping
+} // after Ping
+
+// --- helpers ---
+
+func helper() {}
+`,
+		},
+		{
+			name: "remove takes the comments of the declaration along",
+			edit: func(e *astEdit, decls map[string]*ast.FuncDecl) error {
+				e.removeDecl(decls["Ping"])
+				return nil
+			},
+			expected: `package handler
+
+import "context"
+
+// --- RPCs ---
+
+// Echo is an untouched stub
+func (h *Handler) Echo(ctx context.Context) error {
+	// inside Echo
+	return nil
+} // after Echo
+
+// --- helpers ---
+
+func helper() {}
+`,
+		},
+		{
+			name: "remove and insert in one edit",
+			edit: func(e *astEdit, decls map[string]*ast.FuncDecl) error {
+				e.removeDecl(decls["Echo"])
+				start, _ := declExtent(e.parsed(), decls["Ping"])
+				_, err := e.insertDecls(start, "// Echo moved below the header\nfunc (h *Handler) Echo(ctx context.Context) error { return nil }\n\n")
+				return err
+			},
+			expected: `package handler
+
+import "context"
+
+// --- RPCs ---
+
+// Echo moved below the header
+func (h *Handler) Echo(ctx context.Context) error { return nil }
+
+// Ping is implemented
+func (h *Handler) Ping(ctx context.Context) error {
+	return nil // inside Ping
+} // after Ping
+
+// --- helpers ---
+
+func helper() {}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := newASTEdit(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.edit(e, methodDecls(e.file, "Handler")); err != nil {
+				t.Fatalf("edit failed: %v", err)
+			}
+			result, err := e.print()
+			if err != nil {
+				t.Fatalf("print() failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("print() =\n%s\nwant\n%s", result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migrate converts the handler package in dir between the per_service and
+// per_method layouts, using the state files the plugin writes next to each
+// manifest to find the services. Migrating to per_method moves each RPC
+// method out of the struct file into the file generatePerMethodFiles would
+// create for it; migrating to per_service moves the methods of those files
+// back into the struct file, in proto order. Doc comments and bodies move
+// verbatim along with the imports they use. It returns a description of
+// each change made.
+func Migrate(dir, mode string) ([]string, error) {
+	if mode != modePerService && mode != modePerMethod {
+		return nil, fmt.Errorf("unknown mode %q, want %s or %s", mode, modePerService, modePerMethod)
+	}
+
+	statePaths, err := filepath.Glob(filepath.Join(dir, "*.state.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(statePaths)
+
+	m := &migration{out: newOutputSet(dir), removed: make(map[string]bool)}
+	for _, statePath := range statePaths {
+		state, err := readState(m.out, filepath.Base(statePath))
+		if err != nil {
+			return nil, err
+		}
+		if state == nil || state.RenamedTo != "" {
+			continue
+		}
+
+		base := strings.TrimSuffix(filepath.Base(statePath), ".state.json")
+		if mode == modePerMethod {
+			err = m.split(base, state)
+		} else {
+			err = m.join(base, state)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", statePath, err)
+		}
+		m.out.write(filepath.Base(statePath), marshalState(*state))
+	}
+	if len(statePaths) == 0 {
+		return nil, fmt.Errorf("no state files in %s; run the plugin once to create them", dir)
+	}
+
+	return m.log, m.flush()
+}
+
+// migration accumulates the file changes of a layout migration
+type migration struct {
+	out     *outputSet
+	removed map[string]bool
+	log     []string
+}
+
+// logf records a change made by the migration
+func (m *migration) logf(format string, args ...any) {
+	m.log = append(m.log, fmt.Sprintf(format, args...))
+}
+
+// flush writes the changed files to disk and deletes the emptied ones
+func (m *migration) flush() error {
+	for _, name := range m.out.order {
+		if m.removed[name] {
+			continue
+		}
+		if err := os.WriteFile(constructFullPath(m.out.out, name), []byte(m.out.files[name]), 0o644); err != nil {
+			return err
+		}
+	}
+	for name := range m.removed {
+		if err := os.Remove(constructFullPath(m.out.out, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// split moves the RPC methods of a service's struct file into per-method files
+func (m *migration) split(base string, state *serviceState) error {
+	serviceName := state.Service[strings.LastIndex(state.Service, ".")+1:]
+	structPath := base + ".go"
+	edit, fileImports, err := m.parse(base, structPath)
+	if err != nil || edit == nil {
+		return err
+	}
+	parsed, file := edit.parsed(), edit.file
+	decls := methodDecls(file, state.Struct)
+
+	for i, entry := range state.Methods {
+		decl, ok := decls[entry.Method]
+		if !ok {
+			continue
+		}
+
+		target := methodFileName(serviceName, entry.Method)
+		if m.out.exists(target) {
+			m.logf("%s: kept %s.%s, %s already exists", structPath, state.Struct, entry.Method, target)
+			continue
+		}
+
+		start, end := declExtent(parsed, decl)
+		content := methodFileContent(file.Name.Name, usedImportSpecs(parsed, file, fileImports, decl), parsed.src[start:end])
+		content, err := formatGo(content, fmt.Sprintf("migrated method %s.%s", state.Struct, entry.Method))
+		if err != nil {
+			return err
+		}
+		m.out.write(target, content)
+		edit.removeDecl(decl)
+		state.Methods[i].File = target
+		m.logf("%s: moved %s.%s to %s", structPath, state.Struct, entry.Method, target)
+	}
+
+	return m.rewrite(structPath, edit, fileImports)
+}
+
+// join moves the methods of a service's per-method files back into its
+// struct file, deleting the files left without declarations
+func (m *migration) join(base string, state *serviceState) error {
+	serviceName := state.Service[strings.LastIndex(state.Service, ".")+1:]
+	structPath := base + ".go"
+	structEdit, joined, err := m.parse(base, structPath)
+	if err != nil {
+		return err
+	}
+	if structEdit == nil {
+		return fmt.Errorf("%s does not exist", structPath)
+	}
+	structFile := structEdit.file
+
+	var rpcOrder []string
+	var stubs []methodStub
+	for i, entry := range state.Methods {
+		rpcOrder = append(rpcOrder, entry.Method)
+		name := methodFileName(serviceName, entry.Method)
+		if _, ok := methodDecls(structFile, state.Struct)[entry.Method]; ok {
+			continue
+		}
+
+		edit, fileImports, err := m.parse(base, name)
+		if err != nil {
+			return err
+		}
+		if edit == nil {
+			continue
+		}
+		parsed := edit.parsed()
+		decl, ok := methodDecls(edit.file, state.Struct)[entry.Method]
+		if !ok {
+			continue
+		}
+
+		// The method keeps referring to packages by the names its file used
+		if conflict := addImportNames(joined, importsReferencedBy(decl, fileImports)); conflict != "" {
+			m.logf("%s: kept %s.%s, it imports %s under a name the struct file uses differently", name, state.Struct, entry.Method, conflict)
+			continue
+		}
+
+		start, end := declExtent(parsed, decl)
+		stubs = append(stubs, methodStub{Name: entry.Method, Content: strings.TrimSuffix(parsed.src[start:end], "\n")})
+		state.Methods[i].File = structPath
+		m.logf("%s: moved %s.%s to %s", name, state.Struct, entry.Method, structPath)
+
+		if onlyHoldsMethods(parsed.src, state.Struct, map[string]bool{entry.Method: true}) {
+			m.removed[name] = true
+			m.logf("%s: deleted", name)
+			continue
+		}
+		edit.removeDecl(decl)
+		if err := m.rewrite(name, edit, fileImports); err != nil {
+			return err
+		}
+	}
+
+	merged, err := mergeMethods(structEdit.src, state.Struct, rpcOrder, stubs, joined)
+	if err != nil {
+		return fmt.Errorf("%s: %w", structPath, err)
+	}
+	merged, err = formatGo(merged, "migrated file "+structPath)
+	if err != nil {
+		return err
+	}
+	m.out.write(structPath, merged)
+	return nil
+}
+
+// parse reads and parses a file of the handler directory for editing,
+// resolving its imports with the help of the service's manifest. A missing
+// file yields a nil *astEdit.
+func (m *migration) parse(base, name string) (*astEdit, *Imports, error) {
+	src, exists, err := m.out.read(name)
+	if err != nil || !exists {
+		return nil, nil, err
+	}
+
+	edit, err := newASTEdit(src)
+	if err != nil {
+		return nil, nil, invalidGoError(src, name, 0, err)
+	}
+
+	known, err := manifestImports(m.out, base+".gen.go")
+	if err != nil {
+		return nil, nil, err
+	}
+	return edit, importsForFile(edit.file, known), nil
+}
+
+// rewrite writes a file whose declarations were removed, dropping the
+// imports they were the last users of
+func (m *migration) rewrite(name string, edit *astEdit, imports *Imports) error {
+	src, err := edit.print()
+	if err != nil {
+		return fmt.Errorf("migrated file %s: %w", name, err)
+	}
+	src, err = finalizeGoFile(src, imports, "migrated file "+name)
+	if err != nil {
+		return err
+	}
+	m.out.write(name, src)
+	return nil
+}
+
+// manifestImports returns the package names a service's manifest imports
+// message packages by. Files importing those packages without an alias
+// refer to them by these names.
+func manifestImports(out *outputSet, manifestPath string) (*Imports, error) {
+	im := newImports()
+	src, exists, err := out.read(manifestPath)
+	if err != nil || !exists {
+		return im, err
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), manifestPath, src, parser.ImportsOnly)
+	if err != nil {
+		return nil, invalidGoError(src, manifestPath, 0, err)
+	}
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err == nil && spec.Name != nil {
+			im.pkgNames[importPath] = spec.Name.Name
+		}
+	}
+	return im, nil
+}
+
+// usedImportSpecs returns the source of the import specs of file that decl
+// refers to
+func usedImportSpecs(parsed parsedSource, file *ast.File, imports *Imports, decl ast.Node) []string {
+	used := make(map[Import]bool)
+	for _, imp := range importsReferencedBy(decl, imports) {
+		used[imp] = true
+	}
+
+	var specs []string
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err == nil && used[Import{Path: importPath, Name: imports.localName(spec)}] {
+			specs = append(specs, parsed.text(spec))
+		}
+	}
+	return specs
+}
+
+// methodFileContent assembles a file holding a single method
+func methodFileContent(packageName string, specs []string, method string) string {
+	var std, third []string
+	for _, spec := range specs {
+		importPath := spec[strings.IndexByte(spec, '"'):]
+		if importPath, _ = strconv.Unquote(importPath); (Import{Path: importPath}).isStd() {
+			std = append(std, "\t"+spec+"\n")
+		} else {
+			third = append(third, "\t"+spec+"\n")
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "package %s\n\n", packageName)
+	if len(specs) > 0 {
+		b.WriteString("import (\n" + strings.Join(std, ""))
+		if len(std) > 0 && len(third) > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(strings.Join(third, "") + ")\n\n")
+	}
+	b.WriteString(method)
+	return b.String()
+}
+
+// addImportNames registers imports in im under the names they are used by.
+// It returns the path of the first import im already knows by another name,
+// or whose name im uses for another path.
+func addImportNames(im *Imports, imports []Import) string {
+	for _, imp := range imports {
+		if name, ok := im.byPath[imp.Path]; ok && name != imp.Name {
+			return imp.Path
+		}
+		if importPath, ok := im.byName[imp.Name]; ok && importPath != imp.Path {
+			return imp.Path
+		}
+	}
+	for _, imp := range imports {
+		im.byPath[imp.Path] = imp.Name
+		im.byName[imp.Name] = imp.Path
+	}
+	return ""
+}
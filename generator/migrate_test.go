@@ -0,0 +1,134 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	structFile := `package test_v1
+
+import (
+	"context"
+	"log/slog"
+
+	"connectrpc.com/connect"
+	"example.com/gen/test/v1"
+)
+
+// TestServiceHandler handles TestService RPCs
+type TestServiceHandler struct{ log *slog.Logger }
+
+// Echo answers with the request
+//
+// It is documented at length.
+func (t *TestServiceHandler) Echo(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {
+	// keep me
+	return connect.NewResponse(req.Msg), nil
+} // Echo moves with this comment
+
+// Ping reports a status
+func (t *TestServiceHandler) Ping(ctx context.Context, req *connect.Request[struct{}]) (*connect.Response[struct{}], error) {
+	t.log.InfoContext(ctx, "ping")
+	return connect.NewResponse(&struct{}{}), nil
+}
+`
+	writeTestFiles(t, dir, map[string]string{
+		"test_service_handler.go": structFile,
+		"test_service_handler.gen.go": "// Code generated by protoc-gen-connect-go-handler. DO NOT EDIT.\n\npackage test_v1\n\n" +
+			"import testv1 \"example.com/gen/test/v1\"\n\nvar _ *testv1.EchoRequest\n",
+		"test_service_handler.state.json": marshalState(serviceState{
+			Version: stateVersion,
+			Service: "test.v1.TestService",
+			Struct:  "TestServiceHandler",
+			Methods: []methodState{
+				{RPC: "test.v1.TestService.Echo", Method: "Echo", File: "test_service_handler.go"},
+				{RPC: "test.v1.TestService.Ping", Method: "Ping", File: "test_service_handler.go"},
+			},
+		}),
+	})
+
+	read := func(name string) string {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	// Split into per-method files
+	if _, err := Migrate(dir, modePerMethod); err != nil {
+		t.Fatalf("Migrate(per_method) failed: %v", err)
+	}
+
+	expectedStruct := `package test_v1
+
+import (
+	"log/slog"
+)
+
+// TestServiceHandler handles TestService RPCs
+type TestServiceHandler struct{ log *slog.Logger }
+`
+	if got := read("test_service_handler.go"); got != expectedStruct {
+		t.Errorf("struct file after split =\n%s\nwant\n%s", got, expectedStruct)
+	}
+
+	expectedEcho := `package test_v1
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	"example.com/gen/test/v1"
+)
+
+// Echo answers with the request
+//
+// It is documented at length.
+func (t *TestServiceHandler) Echo(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {
+	// keep me
+	return connect.NewResponse(req.Msg), nil
+} // Echo moves with this comment
+`
+	if got := read("test_service_echo.go"); got != expectedEcho {
+		t.Errorf("test_service_echo.go =\n%s\nwant\n%s", got, expectedEcho)
+	}
+	if got := read("test_service_ping.go"); !strings.Contains(got, "\t\"context\"\n\n\t\"connectrpc.com/connect\"\n)") {
+		t.Errorf("test_service_ping.go should import only what Ping uses\n%s", got)
+	}
+	if got := read("test_service_handler.state.json"); !strings.Contains(got, `"file": "test_service_ping.go"`) {
+		t.Errorf("state file not updated\n%s", got)
+	}
+
+	// Join them back
+	if _, err := Migrate(dir, modePerService); err != nil {
+		t.Fatalf("Migrate(per_service) failed: %v", err)
+	}
+	for _, name := range []string{"test_service_echo.go", "test_service_ping.go"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should have been deleted", name)
+		}
+	}
+
+	joined := read("test_service_handler.go")
+	for _, substr := range []string{
+		"\t\"context\"\n\t\"log/slog\"\n\n\t\"connectrpc.com/connect\"\n",
+		"type TestServiceHandler struct{ log *slog.Logger }\n\n// Echo answers with the request\n",
+		"\t// keep me\n\treturn connect.NewResponse(req.Msg), nil\n} // Echo moves with this comment\n\n// Ping reports a status\n",
+	} {
+		if !strings.Contains(joined, substr) {
+			t.Errorf("joined struct file should contain %q\n%s", substr, joined)
+		}
+	}
+}
+
+func TestMigrateWithoutState(t *testing.T) {
+	if _, err := Migrate(t.TempDir(), modePerMethod); err == nil {
+		t.Error("Migrate() without state files should fail")
+	}
+}
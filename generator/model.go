@@ -0,0 +1,382 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// templateDataVersion is the version of the data templates receive as
+// Context.Version. Version 1 carried names and Go types only; version 2
+// adds the proto file, comments, source locations, options and messages.
+const templateDataVersion = 2
+
+// SourceLocation is where a proto element is declared
+type SourceLocation struct {
+	File string // proto file name, e.g. "test/v1/test_service.proto"
+	Line int    // 1-based, 0 when the request carries no source info
+}
+
+// String returns "file:line", or the file alone when the line is unknown
+func (l SourceLocation) String() string {
+	if l.Line == 0 {
+		return l.File
+	}
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// FileContext describes the proto file declaring a service
+type FileContext struct {
+	Name      string // e.g. "test/v1/test_service.proto"
+	Package   string // proto package, e.g. "test.v1"
+	GoPackage string // Go import path of the generated messages
+	Messages  []*MessageContext
+	Enums     []*EnumContext
+	Options   map[string]string
+}
+
+// MessageContext describes a message
+type MessageContext struct {
+	Name       string // e.g. "Inner"
+	FullName   string // e.g. "test.v1.Outer.Inner"
+	GoName     string // Go type name protoc-gen-go generates, e.g. "Outer_Inner"
+	Fields     []*FieldContext
+	Oneofs     []*OneofContext
+	Messages   []*MessageContext // nested messages
+	Enums      []*EnumContext    // nested enums
+	Comments   string
+	Location   SourceLocation
+	Options    map[string]string
+	Deprecated bool
+}
+
+// FieldContext describes a message field
+type FieldContext struct {
+	Name       string // proto name, e.g. "user_id"
+	GoName     string // Go field name protoc-gen-go generates, e.g. "UserId"
+	JSONName   string // e.g. "userId"
+	Number     int32
+	Type       string // proto type without the TYPE_ prefix, lower case, e.g. "string" or "message"
+	TypeName   string // proto full name of message and enum types, e.g. "test.v1.User"
+	Repeated   bool
+	Optional   bool   // declared with the proto3 optional keyword
+	Oneof      string // name of the oneof the field belongs to, if any
+	Comments   string
+	Location   SourceLocation
+	Options    map[string]string
+	Deprecated bool
+}
+
+// OneofContext describes a oneof of a message
+type OneofContext struct {
+	Name     string
+	Fields   []*FieldContext
+	Comments string
+}
+
+// EnumContext describes an enum
+type EnumContext struct {
+	Name       string
+	FullName   string
+	Values     []*EnumValueContext
+	Comments   string
+	Location   SourceLocation
+	Deprecated bool
+}
+
+// EnumValueContext describes an enum value
+type EnumValueContext struct {
+	Name       string
+	Number     int32
+	Comments   string
+	Deprecated bool
+}
+
+// fileContext describes a proto file with its top-level messages and enums
+func (idx *typeIndex) fileContext(fd *descriptorpb.FileDescriptorProto) *FileContext {
+	file := &FileContext{
+		Name:      fd.GetName(),
+		Package:   fd.GetPackage(),
+		GoPackage: idx.goPackages[fd.GetName()].ImportPath,
+		Options:   optionsOf(fd.GetOptions()),
+	}
+	for _, msg := range fd.GetMessageType() {
+		file.Messages = append(file.Messages, idx.messageContext(msg))
+	}
+	for _, enum := range fd.GetEnumType() {
+		file.Enums = append(file.Enums, idx.enumContext(enum))
+	}
+	return file
+}
+
+// message describes a message by proto full name, or returns nil if the
+// message is not part of the request
+func (idx *typeIndex) message(protoType string) *MessageContext {
+	if idx == nil {
+		return nil
+	}
+	t, ok := idx.lookup(protoType)
+	if !ok || t.msg == nil {
+		return nil
+	}
+	return idx.messageContext(t.msg)
+}
+
+// messageContext describes a message with its fields and nested types
+func (idx *typeIndex) messageContext(msg *descriptorpb.DescriptorProto) *MessageContext {
+	decl := idx.decls[msg]
+	mc := &MessageContext{
+		Name:       msg.GetName(),
+		FullName:   decl.fullName,
+		GoName:     goCamelCase(strings.TrimPrefix(decl.fullName, decl.file.GetPackage()+".")),
+		Comments:   idx.comments(msg),
+		Location:   idx.location(msg),
+		Options:    optionsOf(msg.GetOptions()),
+		Deprecated: msg.GetOptions().GetDeprecated(),
+	}
+
+	for _, oneof := range msg.GetOneofDecl() {
+		mc.Oneofs = append(mc.Oneofs, &OneofContext{Name: oneof.GetName(), Comments: idx.comments(oneof)})
+	}
+	goNames := goFieldNames(msg)
+	for i, field := range msg.GetField() {
+		fc := idx.fieldContext(field, goNames[i])
+		mc.Fields = append(mc.Fields, fc)
+		// Synthetic oneofs of proto3 optional fields are not real oneofs
+		if field.OneofIndex != nil && !field.GetProto3Optional() && int(field.GetOneofIndex()) < len(mc.Oneofs) {
+			oneof := mc.Oneofs[field.GetOneofIndex()]
+			fc.Oneof = oneof.Name
+			oneof.Fields = append(oneof.Fields, fc)
+		}
+	}
+	mc.Oneofs = filterOneofs(mc.Oneofs)
+
+	for _, nested := range msg.GetNestedType() {
+		if nested.GetOptions().GetMapEntry() {
+			continue
+		}
+		mc.Messages = append(mc.Messages, idx.messageContext(nested))
+	}
+	for _, enum := range msg.GetEnumType() {
+		mc.Enums = append(mc.Enums, idx.enumContext(enum))
+	}
+	return mc
+}
+
+// filterOneofs drops the synthetic oneofs left without fields
+func filterOneofs(oneofs []*OneofContext) []*OneofContext {
+	var kept []*OneofContext
+	for _, oneof := range oneofs {
+		if len(oneof.Fields) > 0 {
+			kept = append(kept, oneof)
+		}
+	}
+	return kept
+}
+
+// goFieldNames returns the Go names protoc-gen-go gives the fields of msg,
+// in field order. Like protoc-gen-go, it appends "_" to a name until it
+// clashes neither with a method of generated messages nor with the name or
+// getter of an earlier field or oneof.
+func goFieldNames(msg *descriptorpb.DescriptorProto) []string {
+	used := map[string]bool{
+		"Reset":               true,
+		"String":              true,
+		"ProtoMessage":        true,
+		"Marshal":             true,
+		"Unmarshal":           true,
+		"ExtensionRangeArray": true,
+		"ExtensionMap":        true,
+		"Descriptor":          true,
+	}
+	unique := func(name string, hasGetter bool) string {
+		for used[name] || (hasGetter && used["Get"+name]) {
+			name += "_"
+		}
+		used[name] = true
+		used["Get"+name] = hasGetter
+		return name
+	}
+
+	names := make([]string, len(msg.GetField()))
+	oneofs := make(map[int32]bool)
+	for i, field := range msg.GetField() {
+		names[i] = unique(goCamelCase(field.GetName()), true)
+		// The oneof takes its name after its first field; protoc-gen-go
+		// assumes no getter for it
+		if field.OneofIndex != nil && !oneofs[field.GetOneofIndex()] && int(field.GetOneofIndex()) < len(msg.GetOneofDecl()) {
+			oneofs[field.GetOneofIndex()] = true
+			unique(goCamelCase(msg.GetOneofDecl()[field.GetOneofIndex()].GetName()), false)
+		}
+	}
+	return names
+}
+
+// fieldContext describes a message field named goName in Go
+func (idx *typeIndex) fieldContext(field *descriptorpb.FieldDescriptorProto, goName string) *FieldContext {
+	return &FieldContext{
+		Name:       field.GetName(),
+		GoName:     goName,
+		JSONName:   field.GetJsonName(),
+		Number:     field.GetNumber(),
+		Type:       strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_")),
+		TypeName:   strings.TrimPrefix(field.GetTypeName(), "."),
+		Repeated:   field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED,
+		Optional:   field.GetProto3Optional(),
+		Comments:   idx.comments(field),
+		Location:   idx.location(field),
+		Options:    optionsOf(field.GetOptions()),
+		Deprecated: field.GetOptions().GetDeprecated(),
+	}
+}
+
+// enumContext describes an enum with its values
+func (idx *typeIndex) enumContext(enum *descriptorpb.EnumDescriptorProto) *EnumContext {
+	ec := &EnumContext{
+		Name:       enum.GetName(),
+		FullName:   idx.decls[enum].fullName,
+		Comments:   idx.comments(enum),
+		Location:   idx.location(enum),
+		Deprecated: enum.GetOptions().GetDeprecated(),
+	}
+	for _, value := range enum.GetValue() {
+		ec.Values = append(ec.Values, &EnumValueContext{
+			Name:       value.GetName(),
+			Number:     value.GetNumber(),
+			Comments:   idx.comments(value),
+			Deprecated: value.GetOptions().GetDeprecated(),
+		})
+	}
+	return ec
+}
+
+// location returns where a descriptor of the request is declared
+func (idx *typeIndex) location(desc proto.Message) SourceLocation {
+	decl, ok := idx.decls[desc]
+	if !ok {
+		return SourceLocation{}
+	}
+	loc := SourceLocation{File: decl.file.GetName()}
+	if span := sourceLocation(decl.file, decl.path).GetSpan(); len(span) > 0 {
+		loc.Line = int(span[0]) + 1
+	}
+	return loc
+}
+
+// comments returns the leading and trailing comments of a descriptor of the
+// request as one text, without the space protoc keeps after each "//" and
+// without generator directives
+func (idx *typeIndex) comments(desc proto.Message) string {
+	decl, ok := idx.decls[desc]
+	if !ok {
+		return ""
+	}
+	loc := sourceLocation(decl.file, decl.path)
+
+	var paragraphs []string
+	for _, comment := range []string{loc.GetLeadingComments(), loc.GetTrailingComments()} {
+		if comment = cleanComment(comment); comment != "" {
+			paragraphs = append(paragraphs, comment)
+		}
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// cleanComment strips the space following "//" from each line of a comment
+// as protoc reports it, drops generator directives such as renamed_from and
+// trims surrounding blank lines
+func cleanComment(comment string) string {
+	var lines []string
+	for line := range strings.SplitSeq(comment, "\n") {
+		line = strings.TrimPrefix(line, " ")
+		if strings.HasPrefix(line, renamedFromDirective+":") {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, " \t"))
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// optionsOf returns the options set in an options message by field name.
+// Custom options are listed by their name in parentheses when the plugin
+// links their extension, such as "(buf.validate.oneof)", and otherwise by
+// field number, such as "(50001)", with their raw wire value: integers and
+// bools as unsigned decimals, strings and messages as their bytes. Repeated
+// and message-typed standard options are left out.
+func optionsOf(opts protoreflect.ProtoMessage) map[string]string {
+	m := opts.ProtoReflect()
+	if !m.IsValid() {
+		return nil
+	}
+
+	options := make(map[string]string)
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := string(fd.Name())
+		if fd.IsExtension() {
+			name = "(" + string(fd.FullName()) + ")"
+		}
+		switch {
+		case fd.IsList() || fd.IsMap() || fd.Message() != nil:
+			// uninterpreted_option, features and the like
+		case fd.Enum() != nil:
+			if value := fd.Enum().Values().ByNumber(v.Enum()); value != nil {
+				options[name] = string(value.Name())
+			}
+		default:
+			options[name] = v.String()
+		}
+		return true
+	})
+	unknownOptions(m.GetUnknown(), options)
+	if len(options) == 0 {
+		return nil
+	}
+	return options
+}
+
+// unknownOptions adds the fields of b, the unknown fields of an options
+// message, to options by field number. The last value of a field repeated in
+// b wins.
+func unknownOptions(b []byte, options map[string]string) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return
+		}
+		b = b[n:]
+
+		var value string
+		switch typ {
+		case protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			value = strconv.FormatUint(v, 10)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			value = strconv.FormatUint(uint64(v), 10)
+		case protowire.Fixed64Type:
+			var v uint64
+			v, n = protowire.ConsumeFixed64(b)
+			value = strconv.FormatUint(v, 10)
+		case protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(b)
+			value = string(v)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return
+		}
+		b = b[n:]
+		if typ != protowire.StartGroupType {
+			options[fmt.Sprintf("(%d)", num)] = value
+		}
+	}
+}
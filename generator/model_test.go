@@ -0,0 +1,279 @@
+package generator
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// modelProtoFile returns a proto file exercising every part of the template
+// data model, with source info for the service, the method and a field
+func modelProtoFile() *descriptorpb.FileDescriptorProto {
+	stringType := descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+	return &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("user/v1/user.proto"),
+		Package: proto.String("user.v1"),
+		Options: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/gen/user/v1;userv1")},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("GetUserRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("id"), JsonName: proto.String("id"), Number: proto.Int32(1), Type: stringType, OneofIndex: proto.Int32(0)},
+					{Name: proto.String("email"), JsonName: proto.String("email"), Number: proto.Int32(2), Type: stringType, OneofIndex: proto.Int32(0)},
+					{
+						Name: proto.String("view"), JsonName: proto.String("view"), Number: proto.Int32(3),
+						Type:     descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum(),
+						TypeName: proto.String(".user.v1.GetUserRequest.View"),
+						Options:  &descriptorpb.FieldOptions{Deprecated: proto.Bool(true)},
+					},
+					{Name: proto.String("locale"), JsonName: proto.String("locale"), Number: proto.Int32(4), Type: stringType, OneofIndex: proto.Int32(1), Proto3Optional: proto.Bool(true)},
+				},
+				OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("lookup")}, {Name: proto.String("_locale")}},
+				EnumType: []*descriptorpb.EnumDescriptorProto{{
+					Name: proto.String("View"),
+					Value: []*descriptorpb.EnumValueDescriptorProto{
+						{Name: proto.String("VIEW_UNSPECIFIED"), Number: proto.Int32(0)},
+						{Name: proto.String("VIEW_FULL"), Number: proto.Int32(1)},
+					},
+				}},
+			},
+			{Name: proto.String("User")},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("UserService"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:            proto.String("GetUser"),
+				InputType:       proto.String(".user.v1.GetUserRequest"),
+				OutputType:      proto.String(".user.v1.User"),
+				ServerStreaming: proto.Bool(true),
+				Options: &descriptorpb.MethodOptions{
+					IdempotencyLevel: descriptorpb.MethodOptions_NO_SIDE_EFFECTS.Enum(),
+				},
+			}},
+		}},
+		SourceCodeInfo: &descriptorpb.SourceCodeInfo{
+			Location: []*descriptorpb.SourceCodeInfo_Location{
+				{Path: []int32{6, 0}, Span: []int32{20, 0, 30, 1}, LeadingComments: proto.String(" UserService manages users.\n")},
+				{Path: []int32{6, 0, 2, 0}, Span: []int32{22, 2, 60}, LeadingComments: proto.String(" GetUser streams a user.\n\n Twice.\n")},
+				{Path: []int32{4, 0, 2, 2}, Span: []int32{8, 2, 20}, LeadingComments: proto.String(" How much to return.\n")},
+			},
+		},
+	}
+}
+
+func TestBuildContextModel(t *testing.T) {
+	fd := modelProtoFile()
+	idx := newTypeIndex([]*descriptorpb.FileDescriptorProto{fd}, &Options{})
+	ctx, err := buildContext(fd, fd.GetService()[0], idx, &Options{Mode: modePerService, ImplSuffix: "_handler"})
+	if err != nil {
+		t.Fatalf("buildContext() failed: %v", err)
+	}
+
+	if ctx.Version != templateDataVersion {
+		t.Errorf("Version = %d, want %d", ctx.Version, templateDataVersion)
+	}
+	if ctx.File.Name != "user/v1/user.proto" || ctx.File.Package != "user.v1" || ctx.File.GoPackage != "example.com/gen/user/v1" {
+		t.Errorf("File = %+v", ctx.File)
+	}
+	if got := ctx.File.Options["go_package"]; got != "example.com/gen/user/v1;userv1" {
+		t.Errorf("File.Options[go_package] = %q", got)
+	}
+	if len(ctx.File.Messages) != 2 {
+		t.Errorf("File.Messages has %d messages, want 2", len(ctx.File.Messages))
+	}
+
+	svc := ctx.Service
+	if svc.Comments != "UserService manages users." || svc.Location.String() != "user/v1/user.proto:21" {
+		t.Errorf("Service comments %q at %s", svc.Comments, svc.Location)
+	}
+
+	method := svc.Methods[0]
+	expected := map[string]any{
+		"FullName":        "user.v1.UserService.GetUser",
+		"Procedure":       "/user.v1.UserService/GetUser",
+		"StreamType":      streamServer,
+		"ClientStreaming": false,
+		"ServerStreaming": true,
+		"Comments":        "GetUser streams a user.\n\nTwice.",
+		"Location":        SourceLocation{File: "user/v1/user.proto", Line: 23},
+		"Options":         map[string]string{"idempotency_level": "NO_SIDE_EFFECTS"},
+		"Idempotency":     "NO_SIDE_EFFECTS",
+	}
+	value := reflect.ValueOf(*method)
+	for field, want := range expected {
+		if got := value.FieldByName(field).Interface(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Method.%s = %#v, want %#v", field, got, want)
+		}
+	}
+
+	req := method.Request
+	if req == nil || method.Response == nil {
+		t.Fatalf("Request = %v, Response = %v, want both", req, method.Response)
+	}
+	if req.FullName != "user.v1.GetUserRequest" || req.GoName != "GetUserRequest" || len(req.Fields) != 4 {
+		t.Errorf("Request = %+v", req)
+	}
+
+	// Only the real oneof is listed; proto3 optional fields are not in one
+	if len(req.Oneofs) != 1 || req.Oneofs[0].Name != "lookup" || len(req.Oneofs[0].Fields) != 2 {
+		t.Errorf("Request.Oneofs = %+v", req.Oneofs)
+	}
+	if req.Fields[0].Oneof != "lookup" || req.Fields[3].Oneof != "" || !req.Fields[3].Optional {
+		t.Errorf("oneof membership: id %q, locale %q", req.Fields[0].Oneof, req.Fields[3].Oneof)
+	}
+
+	view := req.Fields[2]
+	if view.Type != "enum" || view.TypeName != "user.v1.GetUserRequest.View" || !view.Deprecated ||
+		view.Comments != "How much to return." || view.Location.Line != 9 {
+		t.Errorf("view field = %+v", view)
+	}
+
+	if len(req.Enums) != 1 || req.Enums[0].FullName != "user.v1.GetUserRequest.View" || len(req.Enums[0].Values) != 2 {
+		t.Errorf("Request.Enums = %+v", req.Enums)
+	}
+}
+
+func TestSourceLocationString(t *testing.T) {
+	tests := []struct {
+		loc      SourceLocation
+		expected string
+	}{
+		{SourceLocation{File: "a.proto", Line: 3}, "a.proto:3"},
+		{SourceLocation{File: "a.proto"}, "a.proto"},
+	}
+	for _, tt := range tests {
+		if got := tt.loc.String(); got != tt.expected {
+			t.Errorf("String() = %q, want %q", got, tt.expected)
+		}
+	}
+}
+
+func TestCleanComment(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"protoc spacing", " Echo echoes.\n It really does.\n", "Echo echoes.\nIt really does."},
+		{"indentation kept", " List:\n   - item\n", "List:\n  - item"},
+		{"directive dropped", " Echo echoes.\n renamed_from: OldEcho\n", "Echo echoes."},
+		{"only a directive", " renamed_from: OldEcho\n", ""},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanComment(tt.input); got != tt.expected {
+				t.Errorf("cleanComment(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGoFieldNames(t *testing.T) {
+	field := func(name string) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{Name: proto.String(name)}
+	}
+	inOneof := func(name string, index int32) *descriptorpb.FieldDescriptorProto {
+		f := field(name)
+		f.OneofIndex = proto.Int32(index)
+		return f
+	}
+
+	tests := []struct {
+		name     string
+		msg      *descriptorpb.DescriptorProto
+		expected []string
+	}{
+		{
+			name:     "camel case",
+			msg:      &descriptorpb.DescriptorProto{Field: []*descriptorpb.FieldDescriptorProto{field("user_id"), field("_private")}},
+			expected: []string{"UserId", "XPrivate"},
+		},
+		{
+			name:     "generated methods",
+			msg:      &descriptorpb.DescriptorProto{Field: []*descriptorpb.FieldDescriptorProto{field("reset"), field("string"), field("descriptor")}},
+			expected: []string{"Reset_", "String_", "Descriptor_"},
+		},
+		{
+			name:     "getter of an earlier field",
+			msg:      &descriptorpb.DescriptorProto{Field: []*descriptorpb.FieldDescriptorProto{field("name"), field("get_name")}},
+			expected: []string{"Name", "GetName_"},
+		},
+		{
+			name:     "field named like an earlier getter",
+			msg:      &descriptorpb.DescriptorProto{Field: []*descriptorpb.FieldDescriptorProto{field("get_name"), field("name")}},
+			expected: []string{"GetName", "Name_"},
+		},
+		{
+			name: "oneof",
+			msg: &descriptorpb.DescriptorProto{
+				Field:     []*descriptorpb.FieldDescriptorProto{inOneof("id", 0), field("lookup"), field("get_lookup")},
+				OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("lookup")}},
+			},
+			expected: []string{"Id", "Lookup_", "GetLookup"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := goFieldNames(tt.msg); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("goFieldNames() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOptionsOf(t *testing.T) {
+	// withUnknown returns opts carrying custom options the plugin does not
+	// link, as protoc sends them
+	withUnknown := func(opts *descriptorpb.MethodOptions, b []byte) *descriptorpb.MethodOptions {
+		opts.ProtoReflect().SetUnknown(b)
+		return opts
+	}
+	var custom []byte
+	custom = protowire.AppendTag(custom, 50001, protowire.VarintType)
+	custom = protowire.AppendVarint(custom, 1)
+	custom = protowire.AppendTag(custom, 50002, protowire.BytesType)
+	custom = protowire.AppendString(custom, "users.read")
+	custom = protowire.AppendTag(custom, 50003, protowire.Fixed32Type)
+	custom = protowire.AppendFixed32(custom, 7)
+
+	tests := []struct {
+		name     string
+		opts     *descriptorpb.MethodOptions
+		expected map[string]string
+	}{
+		{
+			name:     "unset",
+			opts:     nil,
+			expected: nil,
+		},
+		{
+			name:     "standard",
+			opts:     &descriptorpb.MethodOptions{Deprecated: proto.Bool(true), IdempotencyLevel: descriptorpb.MethodOptions_IDEMPOTENT.Enum()},
+			expected: map[string]string{"deprecated": "true", "idempotency_level": "IDEMPOTENT"},
+		},
+		{
+			name:     "message typed and repeated left out",
+			opts:     &descriptorpb.MethodOptions{UninterpretedOption: []*descriptorpb.UninterpretedOption{{}}, Features: &descriptorpb.FeatureSet{}},
+			expected: nil,
+		},
+		{
+			name:     "custom",
+			opts:     withUnknown(&descriptorpb.MethodOptions{Deprecated: proto.Bool(false)}, custom),
+			expected: map[string]string{"deprecated": "false", "(50001)": "1", "(50002)": "users.read", "(50003)": "7"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := optionsOf(tt.opts); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("optionsOf() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
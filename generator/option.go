@@ -10,12 +10,74 @@ const (
 	modePerMethod  = "per_method"
 )
 
+const (
+	orphansWarn      = "warn"
+	orphansDeprecate = "deprecate"
+	orphansList      = "list"
+)
+
+const (
+	deprecatedUnimplemented = "unimplemented"
+	deprecatedSkip          = "skip"
+)
+
 // Options represents the plugin configuration
 type Options struct {
 	Mode       string // "per_service" or "per_method"
 	DirPattern string // directory pattern with placeholders
 	ImplSuffix string // suffix for implementation files
 	Out        string // output directory from buf.gen.yaml
+
+	// HandlerPackage is the Go package name of generated handler packages;
+	// placeholders are expanded as in DirPattern
+	HandlerPackage string
+	// HandlerGoPackage is "import/path;name" of generated handler packages;
+	// placeholders are expanded as in DirPattern
+	HandlerGoPackage string
+
+	// ImportPaths maps proto file names to Go import paths, as given by
+	// protoc-gen-go style M<file>=<import path>[;<package name>] options
+	ImportPaths map[string]string
+	// Module is stripped from Go import paths when turning them into output
+	// directories, like protoc-gen-go's module= option
+	Module string
+
+	// Orphans selects what happens to handler methods whose RPC was removed
+	// from the proto: "warn", "deprecate" or "list"
+	Orphans string
+	// FixSignatures rewrites the parameter and result types of implemented
+	// methods that no longer match their RPC instead of only reporting them
+	FixSignatures bool
+	// DeprecatedRPCs selects what deprecated RPCs nobody implemented get:
+	// "unimplemented" stubs or, with "skip", no stub at all
+	DeprecatedRPCs string
+	// Register generates a Register<Service> function mounting the handler
+	// on an http.ServeMux through the connect-go generated package
+	Register bool
+	// ConnectPackageSuffix is the package_suffix connect-go generates its
+	// package with, "connect" unless configured otherwise
+	ConnectPackageSuffix string
+	// RegistryOut is the directory, relative to Out, of a file mounting
+	// every service of the run at once; it implies Register
+	RegistryOut string
+	// Health and Reflection mount grpc.health.v1 and gRPC server reflection
+	// for the services of the registry
+	Health     bool
+	Reflection bool
+	// ServerScaffold is the directory, relative to Out, of a main package
+	// serving every service of the run, created once; it implies Register
+	ServerScaffold string
+
+	// Tests scaffolds a table-driven test per unary RPC, created once
+	Tests bool
+
+	// SyncDocs rewrites the generator-owned part of the doc comments of
+	// implemented methods to match the proto comments
+	SyncDocs bool
+
+	// TemplateDir holds *.tmpl files replacing the embedded templates of the
+	// same name and partials they share
+	TemplateDir string
 }
 
 // parseOptions parses the plugin parameter string
@@ -25,6 +87,12 @@ func parseOptions(parameter string) (*Options, error) {
 		DirPattern: "",
 		ImplSuffix: "_handler",
 		Out:        "",
+		Orphans:    orphansWarn,
+
+		DeprecatedRPCs:       deprecatedUnimplemented,
+		ConnectPackageSuffix: "connect",
+
+		ImportPaths: make(map[string]string),
 	}
 
 	for pair := range strings.SplitSeq(parameter, ",") {
@@ -34,6 +102,12 @@ func parseOptions(parameter string) (*Options, error) {
 		}
 		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
 
+		// M<file>=<import path> maps a proto file to its Go package
+		if strings.HasPrefix(key, "M") {
+			opts.ImportPaths[key[1:]] = value
+			continue
+		}
+
 		switch key {
 		case "mode":
 			if value == modePerService || value == modePerMethod {
@@ -45,12 +119,54 @@ func parseOptions(parameter string) (*Options, error) {
 			opts.ImplSuffix = value
 		case "out":
 			opts.Out = value
+		case "module":
+			opts.Module = value
+		case "handler_package":
+			opts.HandlerPackage = value
+		case "handler_go_package":
+			opts.HandlerGoPackage = value
+		case "orphans":
+			if value == orphansWarn || value == orphansDeprecate || value == orphansList {
+				opts.Orphans = value
+			}
+		case "fix_signatures":
+			opts.FixSignatures = value == "true"
+		case "deprecated_rpcs":
+			if value == deprecatedUnimplemented || value == deprecatedSkip {
+				opts.DeprecatedRPCs = value
+			}
+		case "register":
+			opts.Register = value == "true"
+		case "registry_out":
+			opts.RegistryOut = value
+		case "health":
+			opts.Health = value == "true"
+		case "reflection":
+			opts.Reflection = value == "true"
+		case "server_scaffold":
+			opts.ServerScaffold = value
+		case "connect_package_suffix":
+			opts.ConnectPackageSuffix = value
+		case "tests":
+			opts.Tests = value == "true"
+		case "sync_docs":
+			opts.SyncDocs = value == "true"
+		case "template_dir":
+			opts.TemplateDir = value
 		}
 	}
 
 	if opts.Out == "" {
 		return nil, fmt.Errorf("missing required option 'out'")
 	}
+	if (opts.Health || opts.Reflection) && opts.RegistryOut == "" {
+		return nil, fmt.Errorf("options 'health' and 'reflection' require 'registry_out'")
+	}
+	// The registry and the server mount each service through its Register
+	// function
+	if opts.RegistryOut != "" || opts.ServerScaffold != "" {
+		opts.Register = true
+	}
 
 	return opts, nil
 }
@@ -75,3 +75,42 @@ func TestParseOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestParseOptionsImportPaths(t *testing.T) {
+	opts, err := parseOptions("out=gen,Mtest/v1/test.proto=example.com/gen/test/v1;testv1,Mcommon.proto=example.com/gen/common,module=example.com/gen")
+	if err != nil {
+		t.Fatalf("parseOptions() failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"test/v1/test.proto": "example.com/gen/test/v1;testv1",
+		"common.proto":       "example.com/gen/common",
+	}
+	if len(opts.ImportPaths) != len(expected) {
+		t.Errorf("ImportPaths = %v, want %v", opts.ImportPaths, expected)
+	}
+	for file, importPath := range expected {
+		if opts.ImportPaths[file] != importPath {
+			t.Errorf("ImportPaths[%v] = %v, want %v", file, opts.ImportPaths[file], importPath)
+		}
+	}
+	if opts.Module != "example.com/gen" {
+		t.Errorf("Module = %v, want %v", opts.Module, "example.com/gen")
+	}
+	if opts.Mode != "per_service" {
+		t.Errorf("Mode = %v, want %v", opts.Mode, "per_service")
+	}
+}
+
+func TestParseOptionsHandlerPackage(t *testing.T) {
+	opts, err := parseOptions("out=gen,handler_package={service_snake},handler_go_package=example.com/internal/{package_path};handler")
+	if err != nil {
+		t.Fatalf("parseOptions() failed: %v", err)
+	}
+	if opts.HandlerPackage != "{service_snake}" {
+		t.Errorf("HandlerPackage = %v, want %v", opts.HandlerPackage, "{service_snake}")
+	}
+	if opts.HandlerGoPackage != "example.com/internal/{package_path};handler" {
+		t.Errorf("HandlerGoPackage = %v, want %v", opts.HandlerGoPackage, "example.com/internal/{package_path};handler")
+	}
+}
@@ -0,0 +1,230 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// orphan is a handler method whose RPC no longer exists in the proto
+type orphan struct {
+	Name string
+	methodLocation
+}
+
+// findOrphans returns the handler methods of structName that svc no longer
+// declares, ordered by file and line. Methods that do not take connect
+// request or stream types are helpers, not RPCs, and are never reported.
+func findOrphans(svc *descriptorpb.ServiceDescriptorProto, structName string, pkg *packageIndex) []orphan {
+	rpcs := make(map[string]bool, len(svc.GetMethod()))
+	for _, method := range svc.GetMethod() {
+		rpcs[method.GetName()] = true
+	}
+
+	var orphans []orphan
+	for name, loc := range pkg.methods[structName] {
+		if loc.RPC && !rpcs[name] {
+			orphans = append(orphans, orphan{Name: name, methodLocation: loc})
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].File != orphans[j].File {
+			return orphans[i].File < orphans[j].File
+		}
+		return orphans[i].Line < orphans[j].Line
+	})
+	return orphans
+}
+
+// handleOrphans warns about handler methods whose RPC was removed and,
+// depending on the orphans option, marks them deprecated or lists them for
+// cleanup. Developer code is never removed.
+func handleOrphans(fileDesc *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto, ctx Context, pkg *packageIndex, out *outputSet, opts *Options) error {
+	var orphans []orphan
+	for _, o := range findOrphans(svc, ctx.StructName, pkg) {
+		warnf("%s:%d: %s.%s has no matching RPC in service %s (%s)",
+			filepath.Join(opts.Out, ctx.Dir, o.File), o.Line, ctx.StructName, o.Name, ctx.Service.Name, fileDesc.GetName())
+		// Files that do not parse are left as they are
+		if !pkg.broken[o.File] {
+			orphans = append(orphans, o)
+		}
+	}
+
+	switch opts.Orphans {
+	case orphansDeprecate:
+		return deprecateOrphans(fileDesc.GetName(), ctx, orphans, out)
+	case orphansList:
+		return listOrphans(fileDesc.GetName(), ctx, orphans, out, opts)
+	}
+	return nil
+}
+
+// deprecateOrphans adds a "Deprecated: RPC removed from <proto file>"
+// paragraph to the doc comment of each orphaned method
+func deprecateOrphans(protoFile string, ctx Context, orphans []orphan, out *outputSet) error {
+	var files []string
+	byFile := make(map[string][]string)
+	for _, o := range orphans {
+		if _, ok := byFile[o.File]; !ok {
+			files = append(files, o.File)
+		}
+		byFile[o.File] = append(byFile[o.File], o.Name)
+	}
+
+	notice := "Deprecated: RPC removed from " + protoFile
+	for _, name := range files {
+		path := filepath.Join(ctx.Dir, name)
+		src, _, err := out.read(path)
+		if err != nil {
+			return err
+		}
+
+		origin := fmt.Sprintf("deprecating removed RPCs in %s", path)
+		updated, err := addDeprecationNotices(src, ctx.StructName, byFile[name], notice)
+		if err != nil {
+			return fmt.Errorf("%s: %w", origin, err)
+		}
+		if updated == src {
+			continue
+		}
+
+		updated, err = formatGo(updated, origin)
+		if err != nil {
+			return err
+		}
+		out.write(path, updated)
+	}
+	return nil
+}
+
+// addDeprecationNotices appends notice as a separate paragraph to the doc
+// comment of the named methods. Methods already marked deprecated are left
+// alone, so running it again changes nothing.
+func addDeprecationNotices(src, structName string, methods []string, notice string) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse existing file: %w", err)
+	}
+	tokFile := fset.File(file.Pos())
+	decls := methodDecls(file, structName)
+
+	var edits []textEdit
+	for _, name := range methods {
+		decl, ok := decls[name]
+		if !ok || isDeprecated(decl.Doc) {
+			continue
+		}
+
+		if decl.Doc == nil {
+			edits = append(edits, textEdit{offset: tokFile.Offset(decl.Pos()), text: "// " + notice + "\n"})
+		} else {
+			edits = append(edits, textEdit{offset: tokFile.Offset(decl.Doc.End()), text: "\n//\n// " + notice})
+		}
+	}
+	return applyEdits(src, edits), nil
+}
+
+// isDeprecated reports whether a doc comment has a "Deprecated:" paragraph
+func isDeprecated(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for line := range strings.SplitSeq(doc.Text(), "\n") {
+		if strings.HasPrefix(line, "Deprecated:") {
+			return true
+		}
+	}
+	return false
+}
+
+// listOrphans writes <service><impl_suffix>.orphans.txt next to the handler,
+// naming the files that only hold orphaned methods and can be deleted, and
+// the orphaned methods living in files with other code. The listing is
+// rewritten on every run once it exists, so it never goes stale.
+func listOrphans(protoFile string, ctx Context, orphans []orphan, out *outputSet, opts *Options) error {
+	listPath := filepath.Join(ctx.Dir, handlerFileBase(ctx.Service.Name, opts)+".orphans.txt")
+	if len(orphans) == 0 && !out.exists(listPath) {
+		return nil
+	}
+
+	var files []string
+	byFile := make(map[string][]orphan)
+	for _, o := range orphans {
+		if _, ok := byFile[o.File]; !ok {
+			files = append(files, o.File)
+		}
+		byFile[o.File] = append(byFile[o.File], o)
+	}
+
+	var removable, shared []string
+	for _, name := range files {
+		src, _, err := out.read(filepath.Join(ctx.Dir, name))
+		if err != nil {
+			return err
+		}
+
+		names := make(map[string]bool)
+		for _, o := range byFile[name] {
+			names[o.Name] = true
+		}
+		if onlyHoldsMethods(src, ctx.StructName, names) {
+			removable = append(removable, name)
+			continue
+		}
+		for _, o := range byFile[name] {
+			shared = append(shared, fmt.Sprintf("# %s:%d: %s.%s", name, o.Line, ctx.StructName, o.Name))
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Handler methods of %s whose RPC was removed from %s.\n", ctx.StructName, protoFile)
+	b.WriteString("# Generated by protoc-gen-connect-go-handler on every run. Paths are relative to this directory.\n")
+	if len(orphans) == 0 {
+		b.WriteString("#\n# No orphaned handler methods.\n")
+	}
+	if len(removable) > 0 {
+		b.WriteString("#\n# Files holding nothing but orphaned methods, safe to delete:\n")
+		for _, name := range removable {
+			b.WriteString(name + "\n")
+		}
+	}
+	if len(shared) > 0 {
+		b.WriteString("#\n# Orphaned methods in files holding other code:\n")
+		for _, line := range shared {
+			b.WriteString(line + "\n")
+		}
+	}
+
+	out.write(listPath, b.String())
+	return nil
+}
+
+// onlyHoldsMethods reports whether the Go source src declares nothing but
+// imports and the named methods of structName
+func onlyHoldsMethods(src, structName string, names map[string]bool) bool {
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, parser.SkipObjectResolution)
+	if err != nil {
+		return false
+	}
+
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			if d.Tok != token.IMPORT {
+				return false
+			}
+		case *ast.FuncDecl:
+			if receiverTypeName(d) != structName || !names[d.Name.Name] {
+				return false
+			}
+		}
+	}
+	return true
+}
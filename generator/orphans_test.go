@@ -0,0 +1,182 @@
+package generator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAddDeprecationNotices(t *testing.T) {
+	const notice = "Deprecated: RPC removed from test.proto"
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "without doc comment",
+			input:    "package p\n\nfunc (h *Handler) Old() {}\n",
+			expected: "package p\n\n// Deprecated: RPC removed from test.proto\nfunc (h *Handler) Old() {}\n",
+		},
+		{
+			name:     "with doc comment",
+			input:    "package p\n\n// Old does things\nfunc (h *Handler) Old() {}\n",
+			expected: "package p\n\n// Old does things\n//\n// Deprecated: RPC removed from test.proto\nfunc (h *Handler) Old() {}\n",
+		},
+		{
+			name:     "already deprecated",
+			input:    "package p\n\n// Old does things\n//\n// Deprecated: use New instead.\nfunc (h *Handler) Old() {}\n",
+			expected: "package p\n\n// Old does things\n//\n// Deprecated: use New instead.\nfunc (h *Handler) Old() {}\n",
+		},
+		{
+			name:     "other receiver",
+			input:    "package p\n\nfunc (o *Other) Old() {}\n",
+			expected: "package p\n\nfunc (o *Other) Old() {}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := addDeprecationNotices(tt.input, "Handler", []string{"Old"}, notice)
+			if err != nil {
+				t.Fatalf("addDeprecationNotices() failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("addDeprecationNotices() =\n%s\nwant\n%s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateOrphans(t *testing.T) {
+	handlerFile := `package test_v1
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	testv1 "example.com/gen/test/v1"
+)
+
+type TestServiceHandler struct{}
+
+// Echo is still declared in the proto
+func (t *TestServiceHandler) Echo(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {
+	return connect.NewResponse(req.Msg), nil
+}
+
+// Ping is still declared in the proto
+func (t *TestServiceHandler) Ping(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {
+	return nil, nil
+}
+
+// Legacy was removed from the proto
+func (t *TestServiceHandler) Legacy(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {
+	return nil, nil
+}
+
+// Close is a helper, not an RPC
+func (t *TestServiceHandler) Close() error { return nil }
+`
+	removedFile := `package test_v1
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+)
+
+func (t *TestServiceHandler) Watch(ctx context.Context, stream *connect.BidiStream[struct{}, struct{}]) error {
+	return nil
+}
+`
+
+	tests := []struct {
+		mode   string
+		check  map[string][]string // response file -> expected substrings
+		absent []string            // response files that must not be emitted
+	}{
+		{
+			mode:   "warn",
+			absent: []string{"test_service_handler.orphans.txt", "removed_impl.go"},
+		},
+		{
+			mode: "deprecate",
+			check: map[string][]string{
+				"test_service_handler.go": {
+					"// Legacy was removed from the proto\n//\n// Deprecated: RPC removed from test/v1/test_service.proto\nfunc (t *TestServiceHandler) Legacy(",
+					"// Close is a helper, not an RPC\nfunc",
+					"// Ping is still declared in the proto\nfunc",
+				},
+				"removed_impl.go": {
+					"// Deprecated: RPC removed from test/v1/test_service.proto\nfunc (t *TestServiceHandler) Watch(",
+				},
+			},
+		},
+		{
+			mode: "list",
+			check: map[string][]string{
+				"test_service_handler.orphans.txt": {
+					"safe to delete:\nremoved_impl.go\n",
+					"# test_service_handler.go:23: TestServiceHandler.Legacy\n",
+				},
+			},
+			absent: []string{"removed_impl.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			writeTestFiles(t, "gen", map[string]string{
+				"test_service_handler.go": handlerFile,
+				"removed_impl.go":         removedFile,
+			})
+
+			var logs bytes.Buffer
+			prev := logOutput
+			logOutput = &logs
+			t.Cleanup(func() { logOutput = prev })
+
+			resp, err := Generate(testRequestWithServices("out=gen,orphans=" + tt.mode))
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+
+			for _, warning := range []string{
+				"gen/test_service_handler.go:23: TestServiceHandler.Legacy has no matching RPC in service TestService (test/v1/test_service.proto)",
+				"gen/removed_impl.go:9: TestServiceHandler.Watch has no matching RPC",
+			} {
+				if !strings.Contains(logs.String(), warning) {
+					t.Errorf("expected warning %q, got:\n%s", warning, logs.String())
+				}
+			}
+			if strings.Contains(logs.String(), "Close") {
+				t.Errorf("helper method reported as orphan:\n%s", logs.String())
+			}
+
+			files := make(map[string]string)
+			for _, file := range resp.File {
+				files[file.GetName()] = file.GetContent()
+			}
+			for name, substrings := range tt.check {
+				content, ok := files[name]
+				if !ok {
+					t.Errorf("expected file %s to be generated", name)
+					continue
+				}
+				for _, substr := range substrings {
+					if !strings.Contains(content, substr) {
+						t.Errorf("%s should contain %q\n%s", name, substr, content)
+					}
+				}
+			}
+			for _, name := range tt.absent {
+				if _, ok := files[name]; ok {
+					t.Errorf("unexpected file %s\n%s", name, files[name])
+				}
+			}
+		})
+	}
+}
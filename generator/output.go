@@ -0,0 +1,70 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// outputSet collects the files emitted during a run. Reads see the content
+// produced so far before falling back to the file on disk, so several steps
+// editing the same file end up in a single response file.
+type outputSet struct {
+	out   string            // output directory the paths are relative to
+	files map[string]string // path -> content
+	order []string          // paths in the order they were first written
+}
+
+// newOutputSet creates an empty output set for files under the out directory
+func newOutputSet(out string) *outputSet {
+	return &outputSet{
+		out:   out,
+		files: make(map[string]string),
+	}
+}
+
+// read returns the current content of a file and whether it exists
+func (o *outputSet) read(name string) (string, bool, error) {
+	if content, ok := o.files[name]; ok {
+		return content, true, nil
+	}
+
+	content, err := os.ReadFile(constructFullPath(o.out, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return string(content), true, nil
+}
+
+// exists reports whether a file was emitted in this run or exists on disk
+func (o *outputSet) exists(name string) bool {
+	if _, ok := o.files[name]; ok {
+		return true
+	}
+	return fileExists(constructFullPath(o.out, name))
+}
+
+// write sets the content of a file, replacing earlier output for it
+func (o *outputSet) write(name, content string) {
+	if _, ok := o.files[name]; !ok {
+		o.order = append(o.order, name)
+	}
+	o.files[name] = content
+}
+
+// responseFiles returns the collected files for the plugin response
+func (o *outputSet) responseFiles() []*pluginpb.CodeGeneratorResponse_File {
+	files := make([]*pluginpb.CodeGeneratorResponse_File, 0, len(o.order))
+	for _, name := range o.order {
+		content := o.files[name]
+		files = append(files, &pluginpb.CodeGeneratorResponse_File{
+			Name:    &name,
+			Content: &content,
+		})
+	}
+	return files
+}
@@ -0,0 +1,169 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// packageIndex records the methods declared in the hand-written Go files of
+// a handler package directory
+type packageIndex struct {
+	methods map[string]map[string]methodLocation // receiver type -> method name -> location
+	types   map[string]string                    // type name -> file declaring it
+	broken  map[string]bool                      // files that do not parse
+}
+
+// methodLocation is where a method is declared
+type methodLocation struct {
+	File string // file name relative to the package directory
+	Line int
+	RPC  bool // whether the signature takes connect request or stream types
+
+	Generated bool   // whether this run emitted the method from a template
+	Summary   string // doc summary the generator keeps in sync, see syncDocs
+	Doc       string // generator-owned part of the doc comment, see syncDocs
+}
+
+// loadPackageIndex parses every non-test Go file in dir. Generated files
+// (those with a "Code generated ... DO NOT EDIT." header) are skipped since
+// they are rewritten on every run. A missing directory yields an empty index.
+//
+// A file that does not parse, typically one being edited, is reported on
+// stderr and indexed as far as it parses, so no stub duplicates a method it
+// declares. It is marked broken and later steps leave it untouched.
+func loadPackageIndex(dir string) (*packageIndex, error) {
+	idx := &packageIndex{
+		methods: make(map[string]map[string]methodLocation),
+		types:   make(map[string]string),
+		broken:  make(map[string]bool),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return idx, nil
+		}
+		return nil, fmt.Errorf("failed to read handler directory: %w", err)
+	}
+
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			var list scanner.ErrorList
+			if !errors.As(err, &list) {
+				return nil, fmt.Errorf("failed to read existing handler file: %w", err)
+			}
+			warnf("%v; leaving the file untouched until it parses", list[0])
+			idx.broken[name] = true
+		}
+		if file == nil || ast.IsGenerated(file) {
+			continue
+		}
+		idx.addFile(fset, name, file)
+	}
+
+	return idx, nil
+}
+
+// addFile records the types and methods declared in file
+func (idx *packageIndex) addFile(fset *token.FileSet, name string, file *ast.File) {
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			if decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				idx.types[spec.(*ast.TypeSpec).Name.Name] = name
+			}
+		case *ast.FuncDecl:
+			recv := receiverTypeName(decl)
+			if recv == "" {
+				continue
+			}
+			idx.add(recv, decl.Name.Name, methodLocation{
+				File: name,
+				Line: fset.Position(decl.Pos()).Line,
+				RPC:  isHandlerSignature(decl),
+			})
+		}
+	}
+}
+
+// add records a method of the given receiver type
+func (idx *packageIndex) add(structName, methodName string, loc methodLocation) {
+	if idx.methods[structName] == nil {
+		idx.methods[structName] = make(map[string]methodLocation)
+	}
+	idx.methods[structName][methodName] = loc
+}
+
+// lookup returns where the method of the given receiver type is declared
+func (idx *packageIndex) lookup(structName, methodName string) (methodLocation, bool) {
+	loc, ok := idx.methods[structName][methodName]
+	return loc, ok
+}
+
+// hasMethod reports whether the package declares the method for the given receiver type
+func (idx *packageIndex) hasMethod(structName, methodName string) bool {
+	_, ok := idx.lookup(structName, methodName)
+	return ok
+}
+
+// complete reports whether every file of the package parsed. Methods
+// declared past a syntax error are not indexed, so nothing is generated for
+// the package until it is complete.
+func (idx *packageIndex) complete() bool {
+	return len(idx.broken) == 0
+}
+
+// renameMethod records that a method was renamed and now lives in file
+func (idx *packageIndex) renameMethod(structName, oldName, newName, file string) {
+	loc := idx.methods[structName][oldName]
+	delete(idx.methods[structName], oldName)
+	loc.File = file
+	idx.add(structName, newName, loc)
+}
+
+// renameType records that a type and its methods were renamed
+func (idx *packageIndex) renameType(oldName, newName string) {
+	if file, ok := idx.types[oldName]; ok {
+		delete(idx.types, oldName)
+		idx.types[newName] = file
+	}
+	if methods, ok := idx.methods[oldName]; ok {
+		delete(idx.methods, oldName)
+		idx.methods[newName] = methods
+	}
+}
+
+// renameFile records that a file was renamed
+func (idx *packageIndex) renameFile(oldName, newName string) {
+	for typeName, file := range idx.types {
+		if file == oldName {
+			idx.types[typeName] = newName
+		}
+	}
+	for _, methods := range idx.methods {
+		for methodName, loc := range methods {
+			if loc.File == oldName {
+				loc.File = newName
+				methods[methodName] = loc
+			}
+		}
+	}
+}
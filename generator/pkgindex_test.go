@@ -0,0 +1,131 @@
+package generator
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadPackageIndex(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"handler.go":   "package handler\n\ntype Handler struct{}\n\nfunc NewHandler() *Handler { return &Handler{} }\n",
+		"echo_impl.go": "package handler\n\nfunc (h *Handler) Echo() {}\n",
+		"multi.go":     "package handler\n\nfunc (h Handler) First() {}\n\nfunc (h *Handler) Second() {}\n\nfunc (o *Other) Echo() {}\n",
+		"handler.gen.go": "// Code generated by protoc-gen-connect-go-handler. DO NOT EDIT.\n\n" +
+			"package handler\n\nfunc (h *Handler) Generated() {}\n",
+		"handler_test.go": "package handler\n\nfunc (h *Handler) TestOnly() {}\n",
+		"notes.txt":       "func (h *Handler) Text() {}\n",
+	})
+
+	idx, err := loadPackageIndex(dir)
+	if err != nil {
+		t.Fatalf("loadPackageIndex() failed: %v", err)
+	}
+
+	tests := []struct {
+		structName string
+		methodName string
+		file       string
+	}{
+		{"Handler", "Echo", "echo_impl.go"},
+		{"Handler", "First", "multi.go"},
+		{"Handler", "Second", "multi.go"},
+		{"Other", "Echo", "multi.go"},
+		{"Handler", "NewHandler", ""},
+		{"Handler", "Generated", ""},
+		{"Handler", "TestOnly", ""},
+		{"Handler", "Text", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.structName+"."+tt.methodName, func(t *testing.T) {
+			loc, ok := idx.lookup(tt.structName, tt.methodName)
+			if ok != (tt.file != "") || loc.File != tt.file {
+				t.Errorf("lookup(%v, %v) = %+v, %v, want file %q", tt.structName, tt.methodName, loc, ok, tt.file)
+			}
+		})
+	}
+}
+
+func TestLoadPackageIndexMissingDir(t *testing.T) {
+	idx, err := loadPackageIndex(filepath.Join(t.TempDir(), "missing"))
+	if err != nil {
+		t.Fatalf("loadPackageIndex() failed: %v", err)
+	}
+	if idx.hasMethod("Handler", "Echo") {
+		t.Error("empty index should not report methods")
+	}
+}
+
+func TestLoadPackageIndexBrokenFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"handler.go": "package handler\n\ntype Handler struct{}\n",
+		"echo.go":    "package handler\n\nfunc (h *Handler) Echo() {}\n\nfunc (h *Handler) Ping() {\n\treturn\n",
+	})
+
+	var logs bytes.Buffer
+	prev := logOutput
+	logOutput = &logs
+	t.Cleanup(func() { logOutput = prev })
+
+	idx, err := loadPackageIndex(dir)
+	if err != nil {
+		t.Fatalf("loadPackageIndex() failed: %v", err)
+	}
+	if !idx.hasMethod("Handler", "Echo") {
+		t.Error("methods before the syntax error should be indexed")
+	}
+	if !idx.broken["echo.go"] || idx.broken["handler.go"] || idx.complete() {
+		t.Errorf("broken = %v, want only echo.go", idx.broken)
+	}
+	if !strings.Contains(logs.String(), "echo.go:6:9: expected '}', found 'EOF'; leaving the file untouched until it parses") {
+		t.Errorf("stderr should report the syntax error:\n%s", logs.String())
+	}
+}
+
+func TestGenerateWithBrokenHandlerFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTestFiles(t, "gen", map[string]string{
+		"test_service_handler.go": "package test_v1\n\ntype TestServiceHandler struct{}\n",
+		"echo.go":                 "package test_v1\n\n// Echo is being edited\nfunc (t *TestServiceHandler) Echo(\n",
+	})
+
+	var logs bytes.Buffer
+	prev := logOutput
+	logOutput = &logs
+	t.Cleanup(func() { logOutput = prev })
+
+	resp, err := Generate(testRequestWithServices("out=gen,mode=per_method,tests=true,sync_docs=true,orphans=deprecate"))
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	if !strings.Contains(logs.String(), "not generating stubs for TestServiceHandler until every file of gen parses") {
+		t.Errorf("stderr should say stubs wait for the package to parse:\n%s", logs.String())
+	}
+
+	// Manifests are regenerated; handler files, stubs, tests and state wait
+	var names []string
+	for _, f := range resp.File {
+		names = append(names, f.GetName())
+	}
+	if want := []string{"test_service_handler.gen.go", "empty_service_handler.gen.go"}; !slices.Equal(names, want) {
+		t.Errorf("generated %v, want %v", names, want)
+	}
+}
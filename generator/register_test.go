@@ -0,0 +1,149 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConnectImportPath(t *testing.T) {
+	pkg := goPackage{ImportPath: "example.com/gen/test/v1", Name: "testv1"}
+	tests := []struct {
+		suffix   string
+		expected string
+	}{
+		{"connect", "example.com/gen/test/v1/testv1connect"},
+		{"rpc", "example.com/gen/test/v1/testv1rpc"},
+		{"", "example.com/gen/test/v1"},
+	}
+
+	for _, tt := range tests {
+		got, err := connectImportPath("test/v1/test_service.proto", pkg, tt.suffix)
+		if err != nil || got != tt.expected {
+			t.Errorf("connectImportPath(%q) = %q, %v, want %q", tt.suffix, got, err, tt.expected)
+		}
+	}
+
+	// Without go_package there is no path to derive the package from
+	_, err := connectImportPath("test/v1/test_service.proto", goPackage{Name: "testv1"}, "connect")
+	if err == nil || !strings.HasPrefix(err.Error(), "test/v1/test_service.proto: ") {
+		t.Errorf("connectImportPath() error = %v, want one naming the proto file", err)
+	}
+}
+
+func TestGenerateRegisterFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     string
+		deprecated string // RPC to deprecate, if any
+		expected   []string
+	}{
+		{
+			name:   "default suffix",
+			params: "out=gen,register=true",
+			expected: []string{
+				"\t\"net/http\"\n\n\t\"connectrpc.com/connect\"\n\t\"example.com/gen/test/v1/testv1connect\"\n)",
+				"func RegisterTestService(mux *http.ServeMux, h TestServiceServer, opts ...connect.HandlerOption) {\n" +
+					"\tmux.Handle(testv1connect.NewTestServiceHandler(h, opts...))\n}",
+			},
+		},
+		{
+			name:     "configured suffix",
+			params:   "out=gen,register=true,connect_package_suffix=rpc",
+			expected: []string{`"example.com/gen/test/v1/testv1rpc"`, "mux.Handle(testv1rpc.NewTestServiceHandler(h, opts...))"},
+		},
+		{
+			name:     "same package",
+			params:   "out=gen,register=true,connect_package_suffix=",
+			expected: []string{`testv1 "example.com/gen/test/v1"`, "mux.Handle(testv1.NewTestServiceHandler(h, opts...))"},
+		},
+		{
+			name:       "skipped deprecated RPCs",
+			params:     "out=gen,register=true,deprecated_rpcs=skip",
+			deprecated: "Ping",
+			expected: []string{
+				"mux.Handle(testv1connect.NewTestServiceHandler(testServiceUnimplementedServer{TestServiceServer: h}, opts...))",
+				"type testServiceUnimplementedServer struct {\n\tTestServiceServer\n\ttestServiceUnimplemented\n}",
+				"type testServiceUnimplemented struct {\n\ttestv1connect.UnimplementedTestServiceHandler\n}",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+
+			req := testRequestWithServices(tt.params)
+			if tt.deprecated != "" {
+				req = withDeprecated(req, tt.deprecated)
+			}
+			resp, err := Generate(req)
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			files := make(map[string]string)
+			for _, f := range resp.File {
+				files[f.GetName()] = f.GetContent()
+			}
+
+			content, ok := files["test_service_handler_register.gen.go"]
+			if !ok {
+				t.Fatalf("register file not generated, got %v", resp.File)
+			}
+			for _, substr := range tt.expected {
+				if !strings.Contains(content, substr) {
+					t.Errorf("register file should contain %q\n%s", substr, content)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateWithoutRegister(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	resp, err := Generate(testRequestWithServices("out=gen"))
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	for _, f := range resp.File {
+		if strings.HasSuffix(f.GetName(), "_register.gen.go") {
+			t.Errorf("%s generated without register=true", f.GetName())
+		}
+	}
+}
+
+func TestGenerateRegisterWithoutGoPackage(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	req := testRequestWithServices("out=gen,register=true")
+	req.GetProtoFile()[2].Options = nil
+	_, err := Generate(req)
+	if err == nil || !strings.Contains(err.Error(), "test/v1/test_service.proto: unable to determine the connect-go package") {
+		t.Errorf("Generate() error = %v, want one naming the proto file", err)
+	}
+}
+
+func TestGenerateRegisterFileKeepsServiceImports(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	req := testRequestWithServices("out=gen,register=true")
+	opts, err := parseOptions(req.GetParameter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := newTypeIndex(req.GetProtoFile(), opts)
+	fileDesc := req.GetProtoFile()[2]
+	ctx, err := buildContext(fileDesc, fileDesc.GetService()[0], idx, opts)
+	if err != nil {
+		t.Fatalf("buildContext() failed: %v", err)
+	}
+
+	if err := generateRegisterFile(ctx, newOutputSet(opts.Out)); err != nil {
+		t.Fatalf("generateRegisterFile() failed: %v", err)
+	}
+	for _, path := range []string{"net/http", ctx.ConnectImportPath} {
+		if _, ok := ctx.Imports.byPath[path]; ok {
+			t.Errorf("generateRegisterFile() added %q to the service's imports", path)
+		}
+	}
+}
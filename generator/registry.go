@@ -0,0 +1,249 @@
+package generator
+
+import (
+	"bufio"
+	"fmt"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// registryFileName is the file written to registry_out
+const registryFileName = "registry.gen.go"
+
+// RegistryContext is the data the registry and server scaffold templates
+// receive
+type RegistryContext struct {
+	Version     int
+	PackageName string
+	Imports     *Imports
+	Services    []*RegistryService
+
+	Health     bool   // mount grpc.health.v1 and add a status setter per service
+	Reflection bool   // mount gRPC server reflection
+	Registry   string // local name of the registry package, in the server scaffold
+}
+
+// RegistryService describes a service of the run as the registry refers to it
+type RegistryService struct {
+	FullName   string   // e.g. "test.v1.TestService"
+	Field      string   // Handlers field, e.g. "TestService"
+	Param      string   // NewHandlers parameter, e.g. "testService"
+	Server     string   // qualified interface type, e.g. "test_v1.TestServiceServer"
+	Register   string   // qualified register function, e.g. "test_v1.RegisterTestService"
+	New        string   // qualified handler constructor, e.g. "test_v1.NewTestServiceHandler"
+	Procedures []string // e.g. "/test.v1.TestService/Echo"
+}
+
+// registry collects the services generated in a run for registry_out
+type registry struct {
+	services []registryEntry
+}
+
+// registryEntry is a generated service with the RPCs it serves
+type registryEntry struct {
+	ctx        Context
+	procedures []string
+}
+
+// add records a generated service
+func (r *registry) add(svc *descriptorpb.ServiceDescriptorProto, ctx Context) {
+	entry := registryEntry{ctx: ctx}
+	for _, method := range svc.GetMethod() {
+		entry.procedures = append(entry.procedures, procedureOf(ctx.types.decls[method].fullName))
+	}
+	r.services = append(r.services, entry)
+}
+
+// generateRegistryFile writes the file mounting every service of the run,
+// or nothing when the run generated no service
+func generateRegistryFile(r *registry, idx *typeIndex, out *outputSet, opts *Options) error {
+	if len(r.services) == 0 {
+		return nil
+	}
+
+	packageName, importPath, err := r.registryPackage(opts)
+	if err != nil {
+		return err
+	}
+
+	data, err := r.context(packageName, importPath, opts)
+	if err != nil {
+		return err
+	}
+	data.Health = opts.Health
+	data.Reflection = opts.Reflection
+	if opts.Health {
+		data.Imports.Add("connectrpc.com/grpchealth", "")
+	}
+	if opts.Reflection {
+		data.Imports.Add("connectrpc.com/grpcreflect", "")
+	}
+
+	content, err := renderRegistryTemplate(TEMPLATE_REGISTRY, data, idx, opts)
+	if err != nil {
+		return err
+	}
+
+	out.write(filepath.Join(opts.RegistryOut, registryFileName), content)
+	return nil
+}
+
+// registryPackage returns the package name and import path of the registry
+func (r *registry) registryPackage(opts *Options) (string, string, error) {
+	dir := constructFullPath(opts.Out, opts.RegistryOut)
+	packageName := detectPackageName(dir)
+	for _, entry := range r.services {
+		// A registry written next to handlers joins their package
+		if packageName == "" && filepath.Clean(entry.ctx.Dir) == filepath.Clean(opts.RegistryOut) {
+			packageName = entry.ctx.PackageName
+		}
+	}
+	if packageName == "" {
+		packageName = cleanPackageName(filepath.Base(opts.RegistryOut))
+	}
+
+	importPath, err := moduleImportPath(dir)
+	if err != nil {
+		return "", "", err
+	}
+	return packageName, importPath, nil
+}
+
+// context describes the services of the run to a file of the package at
+// importPath, importing the handler packages it refers to
+func (r *registry) context(packageName, importPath string, opts *Options) (RegistryContext, error) {
+	data := RegistryContext{
+		Version:     templateDataVersion,
+		PackageName: packageName,
+		Imports:     newImports(),
+	}
+	data.Imports.Add("net/http", "")
+
+	fields := registryFields(r.services)
+	for i, entry := range r.services {
+		qualifier, err := handlerQualifier(entry.ctx, importPath, data.Imports, opts)
+		if err != nil {
+			return RegistryContext{}, err
+		}
+		param := toLowerCamel(fields[i])
+		if token.IsKeyword(param) {
+			param += "_"
+		}
+		data.Services = append(data.Services, &RegistryService{
+			FullName:   entry.ctx.Service.FullName,
+			Field:      fields[i],
+			Param:      param,
+			Server:     qualifier + entry.ctx.Service.Name + "Server",
+			Register:   qualifier + "Register" + entry.ctx.Service.Name,
+			New:        qualifier + "New" + entry.ctx.StructName,
+			Procedures: entry.procedures,
+		})
+	}
+	return data, nil
+}
+
+// renderRegistryTemplate renders a complete Go file from a template
+// receiving the services of the run
+func renderRegistryTemplate(templateName string, data RegistryContext, idx *typeIndex, opts *Options) (string, error) {
+	content, err := executeTemplate(templateName, Context{TemplateDir: opts.TemplateDir, types: idx}, data)
+	if err != nil {
+		return "", err
+	}
+	return finalizeGoFile(content, data.Imports, fmt.Sprintf("template %q for package %s", templateName, data.PackageName))
+}
+
+// registryFields names the Handlers field of each service after the service,
+// qualifying names two proto packages share with the proto package
+func registryFields(services []registryEntry) []string {
+	counts := make(map[string]int)
+	for _, entry := range services {
+		counts[entry.ctx.Service.Name]++
+	}
+
+	fields := make([]string, len(services))
+	for i, entry := range services {
+		fields[i] = entry.ctx.Service.Name
+		if counts[fields[i]] > 1 {
+			protoPackage := strings.TrimSuffix(entry.ctx.Service.FullName, "."+fields[i])
+			fields[i] = goCamelCase(strings.ReplaceAll(protoPackage, ".", "_")) + fields[i]
+		}
+	}
+	return fields
+}
+
+// handlerQualifier returns the prefix the registry refers to the declarations
+// of a service's handler package by, importing the package unless the
+// registry is part of it
+func handlerQualifier(ctx Context, registryImportPath string, imports *Imports, opts *Options) (string, error) {
+	importPath := ctx.ImportPath
+	if importPath == "" {
+		var err error
+		importPath, err = moduleImportPath(constructFullPath(opts.Out, ctx.Dir))
+		if err != nil {
+			return "", err
+		}
+	}
+	if importPath == "" {
+		return "", fmt.Errorf("registry_out: cannot determine the import path of %s; set handler_go_package or add a go.mod",
+			filepath.Join(opts.Out, ctx.Dir))
+	}
+	if importPath == registryImportPath {
+		return "", nil
+	}
+	return imports.Add(importPath, ctx.PackageName) + ".", nil
+}
+
+// moduleImportPath returns the import path of the package in dir according
+// to the go.mod of the module containing it, or "" if there is none
+func moduleImportPath(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for root := abs; ; root = filepath.Dir(root) {
+		module, err := readModulePath(filepath.Join(root, "go.mod"))
+		if err != nil {
+			return "", err
+		}
+		if module != "" {
+			rel, err := filepath.Rel(root, abs)
+			if err != nil {
+				return "", err
+			}
+			return path.Join(module, filepath.ToSlash(rel)), nil
+		}
+		if filepath.Dir(root) == root {
+			return "", nil
+		}
+	}
+}
+
+// readModulePath returns the module path a go.mod declares, or "" if the
+// file does not exist
+func readModulePath(goMod string) (string, error) {
+	f, err := os.Open(goMod)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if module, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "module "); ok {
+			return strings.Trim(strings.TrimSpace(module), `"`), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s: no module directive", goMod)
+}
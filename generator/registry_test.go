@@ -0,0 +1,162 @@
+package generator
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateRegistry(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   string
+		expected []string
+	}{
+		{
+			name:   "module import paths",
+			params: "out=gen,dir_pattern={service_snake},registry_out=registry",
+			expected: []string{
+				"package registry\n",
+				"\t\"net/http\"\n\n\t\"connectrpc.com/connect\"\n" +
+					"\ttest_v11 \"example.com/app/gen/empty_service\"\n" +
+					"\ttest_v1 \"example.com/app/gen/test_service\"\n)",
+				"var Procedures = []string{\n\t\"/test.v1.TestService/Echo\",\n\t\"/test.v1.TestService/Ping\",\n}",
+				"type Handlers struct {\n\tTestService  test_v1.TestServiceServer\n\tEmptyService test_v11.EmptyServiceServer\n}",
+				"func NewHandlers(\n\ttestService test_v1.TestServiceServer,\n\temptyService test_v11.EmptyServiceServer,\n) Handlers {",
+				"\tif h.TestService == nil {\n\t\tpanic(\"RegisterAll: no handler for test.v1.TestService\")\n\t}\n" +
+					"\ttest_v1.RegisterTestService(mux, h.TestService, opts...)\n",
+			},
+		},
+		{
+			name:   "handler_go_package",
+			params: "out=gen,dir_pattern={service_snake},handler_go_package=example.com/handlers/{service_snake};handlers,registry_out=registry",
+			expected: []string{
+				`"example.com/handlers/test_service"`,
+				"handlers.RegisterTestService(mux, h.TestService, opts...)",
+			},
+		},
+		{
+			name:   "registry in the handler package",
+			params: "out=gen,registry_out=.",
+			expected: []string{
+				"package test_v1\n",
+				"\tRegisterTestService(mux, h.TestService, opts...)\n",
+			},
+		},
+		{
+			name:   "health and reflection",
+			params: "out=gen,dir_pattern={service_snake},registry_out=registry,health=true,reflection=true",
+			expected: []string{
+				"\t\"connectrpc.com/grpchealth\"\n\t\"connectrpc.com/grpcreflect\"\n",
+				"var ServiceNames = []string{\n\t\"test.v1.TestService\",\n\t\"test.v1.EmptyService\",\n}",
+				"var Health = grpchealth.NewStaticChecker(ServiceNames...)",
+				"func SetTestServiceStatus(status grpchealth.Status) {\n\tHealth.SetStatus(\"test.v1.TestService\", status)\n}",
+				"\tmux.Handle(grpchealth.NewHandler(Health))\n",
+				"\treflector := grpcreflect.NewStaticReflector(ServiceNames...)\n" +
+					"\tmux.Handle(grpcreflect.NewHandlerV1(reflector))\n" +
+					"\tmux.Handle(grpcreflect.NewHandlerV1Alpha(reflector))\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			if err := os.WriteFile("go.mod", []byte("module example.com/app\n\ngo 1.24\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := Generate(testRequestWithServices(tt.params))
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			files := make(map[string]string)
+			for _, f := range resp.File {
+				files[f.GetName()] = f.GetContent()
+			}
+
+			var content string
+			for name, c := range files {
+				if strings.HasSuffix(name, registryFileName) {
+					content = c
+				}
+			}
+			if content == "" {
+				t.Fatalf("registry not generated, got %v", resp.File)
+			}
+			for _, substr := range tt.expected {
+				if !strings.Contains(content, substr) {
+					t.Errorf("registry should contain %q\n%s", substr, content)
+				}
+			}
+
+			// The registry calls the Register function of every service
+			registers := 0
+			for name := range files {
+				if strings.HasSuffix(name, "_register.gen.go") {
+					registers++
+				}
+			}
+			if registers != 2 {
+				t.Errorf("%d register files generated, want 2", registers)
+			}
+		})
+	}
+}
+
+func TestGenerateRegistryWithoutModule(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	_, err := Generate(testRequestWithServices("out=gen,registry_out=registry"))
+	if err == nil || !strings.Contains(err.Error(), "cannot determine the import path") {
+		t.Errorf("Generate() error = %v, want an import path error", err)
+	}
+}
+
+func TestGenerateRegistryWithoutHealthOrReflection(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("go.mod", []byte("module example.com/app\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := Generate(testRequestWithServices("out=gen,registry_out=registry"))
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	for _, f := range resp.File {
+		if f.GetName() != "registry/"+registryFileName {
+			continue
+		}
+		for _, substr := range []string{"grpchealth", "grpcreflect", "SetTestServiceStatus"} {
+			if strings.Contains(f.GetContent(), substr) {
+				t.Errorf("registry should not contain %q\n%s", substr, f.GetContent())
+			}
+		}
+	}
+}
+
+func TestHealthRequiresRegistry(t *testing.T) {
+	for _, params := range []string{"out=gen,health=true", "out=gen,reflection=true"} {
+		if _, err := parseOptions(params); err == nil || !strings.Contains(err.Error(), "require 'registry_out'") {
+			t.Errorf("parseOptions(%q) error = %v, want a registry_out error", params, err)
+		}
+	}
+}
+
+func TestRegistryFields(t *testing.T) {
+	entry := func(fullName string) registryEntry {
+		name := fullName[strings.LastIndex(fullName, ".")+1:]
+		return registryEntry{ctx: Context{Service: &ServiceContext{Name: name, FullName: fullName}}}
+	}
+
+	got := registryFields([]registryEntry{
+		entry("user.v1.UserService"),
+		entry("user.v2.UserService"),
+		entry("test.v1.TestService"),
+	})
+	expected := []string{"UserV1UserService", "UserV2UserService", "TestService"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("registryFields() = %q, want %q", got, expected)
+	}
+}
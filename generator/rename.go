@@ -0,0 +1,481 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// renamedFromDirective states the earlier name of a renamed service or RPC
+// in its proto comments, e.g. "// renamed_from: Echo"
+const renamedFromDirective = "renamed_from"
+
+// applyRenames follows renamed_from directives on the service and its RPCs,
+// renaming the handler struct, methods and files named after them so the
+// existing implementation is kept
+func applyRenames(fileDesc *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto, ctx Context, idx *typeIndex, pkg *packageIndex, state *serviceState, out *outputSet, opts *Options) error {
+	if err := renameService(fileDesc, svc, ctx, idx, pkg, out, opts); err != nil {
+		return err
+	}
+	return renameMethods(fileDesc, svc, ctx, pkg, state, out)
+}
+
+// renameService renames the handler struct, its constructor and the files
+// named after the service when the service states its earlier name
+func renameService(fileDesc *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto, ctx Context, idx *typeIndex, pkg *packageIndex, out *outputSet, opts *Options) error {
+	from := commentDirective(sourceLocation(fileDesc, servicePath(fileDesc, svc)), renamedFromDirective)
+	oldName := from[strings.LastIndex(from, ".")+1:]
+	if oldName == "" || oldName == svc.GetName() {
+		return nil
+	}
+
+	oldDir, err := expandPattern(opts.DirPattern, fileDesc, &descriptorpb.ServiceDescriptorProto{Name: &oldName}, idx, opts)
+	if err != nil {
+		return err
+	}
+	oldBase := filepath.Join(oldDir, handlerFileBase(oldName, opts))
+
+	oldStruct := oldName + "Handler"
+	oldState, err := readState(out, oldBase+".state.json")
+	if err != nil {
+		return err
+	}
+	if oldState != nil && oldState.Struct != "" {
+		oldStruct = oldState.Struct
+	}
+
+	// Nothing to do once the rename went through
+	if _, ok := pkg.types[ctx.StructName]; ok {
+		return nil
+	}
+	if oldDir != ctx.Dir {
+		oldPkg, err := loadPackageIndex(constructFullPath(opts.Out, oldDir))
+		if err != nil {
+			return fmt.Errorf("%s: %w", oldDir, err)
+		}
+		if _, ok := oldPkg.types[oldStruct]; ok {
+			warnf("%s: cannot follow the rename of service %s to %s into another directory; move %s to %s by hand",
+				ctx.Service.FullName, oldName, svc.GetName(), filepath.Join(opts.Out, oldDir), filepath.Join(opts.Out, ctx.Dir))
+		}
+		return nil
+	}
+	if _, ok := pkg.types[oldStruct]; !ok {
+		return nil
+	}
+	if !pkg.complete() {
+		warnf("%s: postponing the rename of %s to %s until every file of %s parses",
+			ctx.Service.FullName, oldStruct, ctx.StructName, filepath.Join(opts.Out, ctx.Dir))
+		return nil
+	}
+
+	// Rename the struct, its constructor and the scaffolded tests wherever
+	// the package uses them
+	renames := identRenames{
+		structName:  oldStruct,
+		packageName: ctx.PackageName,
+		importPath:  ctx.ImportPath,
+		idents: map[string]string{
+			oldStruct:         ctx.StructName,
+			"New" + oldStruct: "New" + ctx.StructName,
+		},
+	}
+	var methodNames []string
+	for _, method := range svc.GetMethod() {
+		methodNames = append(methodNames, method.GetName())
+		if from := commentDirective(sourceLocation(fileDesc, methodPath(fileDesc, svc, method)), renamedFromDirective); from != "" {
+			methodNames = append(methodNames, from)
+		}
+	}
+	for _, methodName := range methodNames {
+		renames.idents[testFuncName(oldStruct, methodName)] = testFuncName(ctx.StructName, methodName)
+	}
+	if err := renameInPackage(out, ctx.Dir, renames, fmt.Sprintf("%s to %s", oldStruct, ctx.StructName)); err != nil {
+		return err
+	}
+	pkg.renameType(oldStruct, ctx.StructName)
+	infof("%s: renamed %s to %s following %s: %s", ctx.Service.FullName, oldStruct, ctx.StructName, renamedFromDirective, from)
+
+	// Move the files named after the old service
+	moves := [][2]string{{filepath.Base(oldBase) + ".go", filepath.Base(ctx.StructPath)}}
+	for _, methodName := range methodNames {
+		if loc, ok := pkg.lookup(ctx.StructName, methodName); ok && loc.File == methodFileName(oldName, methodName) {
+			moves = append(moves, [2]string{loc.File, methodFileName(svc.GetName(), methodName)})
+		}
+		moves = append(moves, [2]string{testFileName(oldName, methodName), testFileName(svc.GetName(), methodName)})
+	}
+	for _, move := range moves {
+		from, to := move[0], move[1]
+		note := fmt.Sprintf("Service %s was renamed to %s and this file moved to %s.", oldName, svc.GetName(), to)
+		if moveFile(out, ctx, from, to, note) {
+			pkg.renameFile(from, to)
+		}
+	}
+
+	// The old manifest still declares the old interface and must not compile
+	// against the renamed struct
+	if out.exists(oldBase + ".gen.go") {
+		out.write(oldBase+".gen.go", fmt.Sprintf(
+			"// Code generated by protoc-gen-connect-go-handler. DO NOT EDIT.\n\npackage %s\n\n"+
+				"// Service %s was renamed to %s; this file is no longer used and can be deleted.\n",
+			ctx.PackageName, oldName, svc.GetName()))
+	}
+	if oldState != nil {
+		out.write(oldBase+".state.json", marshalState(serviceState{
+			Version:   stateVersion,
+			Service:   oldState.Service,
+			Struct:    oldState.Struct,
+			RenamedTo: ctx.Service.FullName,
+		}))
+	}
+	return nil
+}
+
+// renameMethods renames the Go method implementing each RPC that states its
+// earlier name, along with its per-method file
+func renameMethods(fileDesc *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto, ctx Context, pkg *packageIndex, state *serviceState, out *outputSet) error {
+	for _, method := range svc.GetMethod() {
+		from := commentDirective(sourceLocation(fileDesc, methodPath(fileDesc, svc, method)), renamedFromDirective)
+		if from == "" || pkg.hasMethod(ctx.StructName, method.GetName()) {
+			continue
+		}
+
+		rpc := ctx.Service.FullName + "." + method.GetName()
+		oldRPC := ctx.Service.FullName + "." + from
+		if strings.Contains(from, ".") {
+			oldRPC = from
+		}
+		oldName := oldRPC[strings.LastIndex(oldRPC, ".")+1:]
+		if oldRPC != ctx.Service.FullName+"."+oldName {
+			warnf("%s: %s: %s names an RPC of another service; move the implementation by hand",
+				rpc, renamedFromDirective, from)
+			continue
+		}
+
+		// The state file knows the Go method implementing the old RPC
+		if entry, ok := state.lookup(oldRPC); ok {
+			oldName = entry.Method
+		}
+		loc, ok := pkg.lookup(ctx.StructName, oldName)
+		if !ok {
+			warnf("%s: %s: %s has no method %s to rename", rpc, renamedFromDirective, ctx.StructName, oldName)
+			continue
+		}
+		if !pkg.complete() {
+			warnf("%s: postponing the rename of %s.%s to %s until every file of %s parses",
+				rpc, ctx.StructName, oldName, method.GetName(), filepath.Join(out.out, ctx.Dir))
+			continue
+		}
+
+		path := filepath.Join(ctx.Dir, loc.File)
+		src, _, err := out.read(path)
+		if err != nil {
+			return err
+		}
+		origin := fmt.Sprintf("renaming %s.%s in %s", ctx.StructName, oldName, path)
+		updated, err := renameMethodDecl(src, ctx.StructName, oldName, method.GetName())
+		if err != nil {
+			return fmt.Errorf("%s: %w", origin, err)
+		}
+		updated, err = formatGo(updated, origin)
+		if err != nil {
+			return err
+		}
+		out.write(path, updated)
+
+		// Calls through values of the handler type follow the rename
+		renames := identRenames{
+			structName:  ctx.StructName,
+			packageName: ctx.PackageName,
+			importPath:  ctx.ImportPath,
+			methods:     map[string]string{oldName: method.GetName()},
+			idents: map[string]string{
+				testFuncName(ctx.StructName, oldName): testFuncName(ctx.StructName, method.GetName()),
+			},
+		}
+		if err := renameInPackage(out, ctx.Dir, renames, fmt.Sprintf("%s.%s to %s", ctx.StructName, oldName, method.GetName())); err != nil {
+			return err
+		}
+		infof("%s:%d: renamed %s.%s to %s following %s: %s",
+			filepath.Join(out.out, path), loc.Line, ctx.StructName, oldName, method.GetName(), renamedFromDirective, from)
+
+		// Per-method files and their scaffolded tests are named after the RPC
+		file := loc.File
+		if file == methodFileName(svc.GetName(), oldName) {
+			newFile := methodFileName(svc.GetName(), method.GetName())
+			note := fmt.Sprintf("%s was renamed to %s and moved to %s.", oldName, method.GetName(), newFile)
+			if moveFile(out, ctx, file, newFile, note) {
+				file = newFile
+			}
+		}
+		newTest := testFileName(svc.GetName(), method.GetName())
+		moveFile(out, ctx, testFileName(svc.GetName(), oldName), newTest,
+			fmt.Sprintf("The tests of %s were renamed to %s and moved to %s.", oldName, method.GetName(), newTest))
+		pkg.renameMethod(ctx.StructName, oldName, method.GetName(), file)
+	}
+	return nil
+}
+
+// renameMethodDecl renames the declaration of a method of structName. A doc
+// comment starting with the old name is updated to start with the new one.
+func renameMethodDecl(src, structName, oldName, newName string) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse existing file: %w", err)
+	}
+	tokFile := fset.File(file.Pos())
+
+	decl, ok := methodDecls(file, structName)[oldName]
+	if !ok {
+		return src, nil
+	}
+
+	edits := []textEdit{{
+		offset: tokFile.Offset(decl.Name.Pos()),
+		end:    tokFile.Offset(decl.Name.End()),
+		text:   newName,
+	}}
+	if decl.Doc != nil {
+		first := decl.Doc.List[0]
+		switch {
+		case first.Text == fmt.Sprintf("// %s implements the %s RPC", oldName, oldName):
+			edits = append(edits, textEdit{
+				offset: tokFile.Offset(first.Pos()),
+				end:    tokFile.Offset(first.End()),
+				text:   fmt.Sprintf("// %s implements the %s RPC", newName, newName),
+			})
+		case strings.HasPrefix(first.Text, "// "+oldName+" "):
+			start := tokFile.Offset(first.Pos()) + len("// ")
+			edits = append(edits, textEdit{offset: start, end: start + len(oldName), text: newName})
+		}
+	}
+	return applyEdits(src, edits), nil
+}
+
+// identRenames lists what renameIdents renames in the files of a handler
+// package
+type identRenames struct {
+	structName  string            // the handler type
+	packageName string            // the handler package
+	importPath  string            // import path of the handler package, if known
+	idents      map[string]string // package-level identifiers, old name -> new name
+	methods     map[string]string // methods of structName, old name -> new name
+}
+
+// renameIdents renames the references in src to the package-level
+// identifiers in r.idents, made from the handler package or from a test
+// package importing it, and the methods in r.methods where they are declared
+// on r.structName or selected on a value of that type. Other packages'
+// identifiers, fields, locals and the methods of other types keep their
+// names, so http.Get is untouched when a Get method is renamed.
+func renameIdents(src string, r identRenames) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse existing file: %w", err)
+	}
+	tokFile := fset.File(file.Pos())
+
+	// Local names the file imports the handler package by
+	handlerPkgs := make(map[string]bool)
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		base := path[strings.LastIndex(path, "/")+1:]
+		if path != r.importPath && (r.importPath != "" || base != r.packageName) {
+			continue
+		}
+		if spec.Name != nil {
+			base = spec.Name.Name
+		}
+		handlerPkgs[base] = true
+	}
+
+	// ref reports whether expr refers to the package-level identifier name of
+	// the handler package
+	ref := func(expr ast.Expr, name string) bool {
+		switch e := expr.(type) {
+		case *ast.Ident:
+			return e.Name == name && (e.Obj == nil || e.Obj == file.Scope.Lookup(name))
+		case *ast.SelectorExpr:
+			pkg, ok := e.X.(*ast.Ident)
+			return ok && pkg.Obj == nil && handlerPkgs[pkg.Name] && e.Sel.Name == name
+		}
+		return false
+	}
+
+	// handler reports whether expr is a value of the handler type, as far as
+	// the declarations of the file tell
+	handlerType := func(expr ast.Expr) bool {
+		if star, ok := expr.(*ast.StarExpr); ok {
+			expr = star.X
+		}
+		return ref(expr, r.structName)
+	}
+	var handler func(expr ast.Expr, depth int) bool
+	handler = func(expr ast.Expr, depth int) bool {
+		if depth > 8 {
+			return false
+		}
+		switch e := ast.Unparen(expr).(type) {
+		case *ast.CallExpr:
+			return ref(e.Fun, "New"+r.structName)
+		case *ast.UnaryExpr:
+			return e.Op == token.AND && handler(e.X, depth+1)
+		case *ast.CompositeLit:
+			return e.Type != nil && handlerType(e.Type)
+		case *ast.Ident:
+			if e.Obj == nil || e.Obj.Kind != ast.Var {
+				return false
+			}
+			switch decl := e.Obj.Decl.(type) {
+			case *ast.Field:
+				return handlerType(decl.Type)
+			case *ast.ValueSpec:
+				if decl.Type != nil {
+					return handlerType(decl.Type)
+				}
+				for i, name := range decl.Names {
+					if name.Name == e.Name && i < len(decl.Values) && len(decl.Values) == len(decl.Names) {
+						return handler(decl.Values[i], depth+1)
+					}
+				}
+			case *ast.AssignStmt:
+				for i, lhs := range decl.Lhs {
+					if id, ok := lhs.(*ast.Ident); ok && id.Name == e.Name && len(decl.Rhs) == len(decl.Lhs) {
+						return handler(decl.Rhs[i], depth+1)
+					}
+				}
+			}
+		}
+		return false
+	}
+
+	var edits []textEdit
+	rename := func(ident *ast.Ident, newName string) {
+		edits = append(edits, textEdit{
+			offset: tokFile.Offset(ident.Pos()),
+			end:    tokFile.Offset(ident.End()),
+			text:   newName,
+		})
+	}
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			if newName, ok := r.methods[n.Name.Name]; ok && receiverTypeName(n) == r.structName {
+				rename(n.Name, newName)
+			}
+			if n.Recv != nil {
+				// A method name is not a package-level identifier
+				ast.Inspect(n.Recv, visit)
+				ast.Inspect(n.Type, visit)
+				if n.Body != nil {
+					ast.Inspect(n.Body, visit)
+				}
+				return false
+			}
+		case *ast.SelectorExpr:
+			if newName, ok := r.methods[n.Sel.Name]; ok && handler(n.X, 0) {
+				rename(n.Sel, newName)
+			} else if newName, ok := r.idents[n.Sel.Name]; ok && ref(n, n.Sel.Name) {
+				rename(n.Sel, newName)
+			}
+			// The selected name is a field, method or another package's
+			// identifier, never one of the package's own
+			ast.Inspect(n.X, visit)
+			return false
+		case *ast.Ident:
+			if newName, ok := r.idents[n.Name]; ok && ref(n, n.Name) {
+				rename(n, newName)
+			}
+		}
+		return true
+	}
+	ast.Inspect(file, visit)
+	return applyEdits(src, edits), nil
+}
+
+// renameInPackage applies renameIdents to the handwritten files of dir;
+// what describes the rename in errors
+func renameInPackage(out *outputSet, dir string, r identRenames, what string) error {
+	files, err := handwrittenGoFiles(out, dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		path := filepath.Join(dir, name)
+		src, _, err := out.read(path)
+		if err != nil {
+			return err
+		}
+
+		origin := fmt.Sprintf("renaming %s in %s", what, path)
+		updated, err := renameIdents(src, r)
+		if err != nil {
+			return fmt.Errorf("%s: %w", origin, err)
+		}
+		if updated != src {
+			out.write(path, updated)
+		}
+	}
+	return nil
+}
+
+// handwrittenGoFiles returns the names of the Go files in dir, tests
+// included, that were not produced by a code generator
+func handwrittenGoFiles(out *outputSet, dir string) ([]string, error) {
+	entries, err := os.ReadDir(constructFullPath(out.out, dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read handler directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") {
+			continue
+		}
+
+		src, _, err := out.read(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), name, src, parser.ParseComments|parser.PackageClauseOnly)
+		if err != nil || ast.IsGenerated(file) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// moveFile moves a file of the handler directory to a new name. Plugins
+// cannot delete files, so the old file is emptied down to its package
+// clause and a note. It reports false if the target already exists.
+func moveFile(out *outputSet, ctx Context, from, to, note string) bool {
+	fromPath, toPath := filepath.Join(ctx.Dir, from), filepath.Join(ctx.Dir, to)
+	content, exists, err := out.read(fromPath)
+	if err != nil || !exists {
+		return false
+	}
+	if out.exists(toPath) {
+		warnf("%s: not moved to %s, which already exists", filepath.Join(out.out, fromPath), to)
+		return false
+	}
+
+	out.write(toPath, content)
+	out.write(fromPath, fmt.Sprintf("package %s\n\n// %s\n// Protoc plugins cannot delete files, so this one was emptied; remove it.\n",
+		ctx.PackageName, note))
+	infof("%s: moved to %s; the emptied file can be deleted", filepath.Join(out.out, fromPath), to)
+	return true
+}
@@ -0,0 +1,289 @@
+package generator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// withComment attaches a leading comment to the element at path of the
+// first file to generate
+func withComment(req *pluginpb.CodeGeneratorRequest, path []int32, comment string) *pluginpb.CodeGeneratorRequest {
+	for _, fd := range req.GetProtoFile() {
+		if fd.GetName() != req.GetFileToGenerate()[0] {
+			continue
+		}
+		if fd.SourceCodeInfo == nil {
+			fd.SourceCodeInfo = &descriptorpb.SourceCodeInfo{}
+		}
+		fd.SourceCodeInfo.Location = append(fd.SourceCodeInfo.Location, &descriptorpb.SourceCodeInfo_Location{
+			Path:            path,
+			LeadingComments: proto.String(comment),
+		})
+	}
+	return req
+}
+
+func TestRenameMethodDecl(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "generated doc",
+			input:    "package p\n\n// Old implements the Old RPC\nfunc (h *Handler) Old() {}\n",
+			expected: "package p\n\n// New implements the New RPC\nfunc (h *Handler) New() {}\n",
+		},
+		{
+			name:     "developer doc",
+			input:    "package p\n\n// Old says hello to the Old world\nfunc (h *Handler) Old() {}\n",
+			expected: "package p\n\n// New says hello to the Old world\nfunc (h *Handler) New() {}\n",
+		},
+		{
+			name:     "no doc",
+			input:    "package p\n\nfunc (h *Handler) Old() { h.Other() }\n",
+			expected: "package p\n\nfunc (h *Handler) New() { h.Other() }\n",
+		},
+		{
+			name:     "other receiver",
+			input:    "package p\n\nfunc (o *Other) Old() {}\n",
+			expected: "package p\n\nfunc (o *Other) Old() {}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := renameMethodDecl(tt.input, "Handler", "Old", "New")
+			if err != nil {
+				t.Fatalf("renameMethodDecl() failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("renameMethodDecl() =\n%s\nwant\n%s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRenameIdents(t *testing.T) {
+	methods := identRenames{structName: "Handler", packageName: "handler", methods: map[string]string{"Get": "Fetch"}}
+	types := identRenames{
+		structName:  "Handler",
+		packageName: "handler",
+		idents:      map[string]string{"Handler": "Service", "NewHandler": "NewService"},
+	}
+
+	tests := []struct {
+		name     string
+		renames  identRenames
+		input    string
+		expected string
+	}{
+		{
+			name:    "method declaration and calls on the handler",
+			renames: methods,
+			input: "package handler\n\nfunc (h *Handler) Get() {}\n\nfunc (h *Handler) Other() { h.Get() }\n\n" +
+				"func use(x Handler) {\n\tx.Get()\n\ty := NewHandler()\n\ty.Get()\n\tz := &Handler{}\n\tz.Get()\n\tNewHandler().Get()\n}\n",
+			expected: "package handler\n\nfunc (h *Handler) Fetch() {}\n\nfunc (h *Handler) Other() { h.Fetch() }\n\n" +
+				"func use(x Handler) {\n\tx.Fetch()\n\ty := NewHandler()\n\ty.Fetch()\n\tz := &Handler{}\n\tz.Fetch()\n\tNewHandler().Fetch()\n}\n",
+		},
+		{
+			name:    "other packages and types",
+			renames: methods,
+			input: "package handler\n\nimport \"net/http\"\n\nfunc (o *Other) Get() {}\n\n" +
+				"func (h *Handler) Other(resp *Response) {\n\thttp.Get(\"/\")\n\tresp.Msg.Get()\n\tvar Get int\n\t_ = Get\n}\n",
+			expected: "package handler\n\nimport \"net/http\"\n\nfunc (o *Other) Get() {}\n\n" +
+				"func (h *Handler) Other(resp *Response) {\n\thttp.Get(\"/\")\n\tresp.Msg.Get()\n\tvar Get int\n\t_ = Get\n}\n",
+		},
+		{
+			name:    "type and constructor",
+			renames: types,
+			input: "package handler\n\ntype Handler struct{}\n\nfunc NewHandler() *Handler { return &Handler{} }\n\n" +
+				"func (h *Handler) Handler() {}\n\nfunc use(s Wrapper) {\n\ts.Handler.Run()\n\tNewHandler := 1\n\t_ = NewHandler\n}\n",
+			expected: "package handler\n\ntype Service struct{}\n\nfunc NewService() *Service { return &Service{} }\n\n" +
+				"func (h *Service) Handler() {}\n\nfunc use(s Wrapper) {\n\ts.Handler.Run()\n\tNewHandler := 1\n\t_ = NewHandler\n}\n",
+		},
+		{
+			name:    "external test package",
+			renames: types,
+			input: "package handler_test\n\nimport (\n\t\"example.com/handler\"\n\t\"example.com/other\"\n)\n\n" +
+				"var h *handler.Handler = handler.NewHandler()\n\nvar o = other.NewHandler()\n",
+			expected: "package handler_test\n\nimport (\n\t\"example.com/handler\"\n\t\"example.com/other\"\n)\n\n" +
+				"var h *handler.Service = handler.NewService()\n\nvar o = other.NewHandler()\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := renameIdents(tt.input, tt.renames)
+			if err != nil {
+				t.Fatalf("renameIdents() failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("renameIdents() =\n%s\nwant\n%s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateFollowsRenames(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   string
+		path     []int32 // source path of the element carrying the directive
+		comment  string
+		existing map[string]string
+		check    map[string][]string // response file -> expected substrings
+	}{
+		{
+			name:    "rpc",
+			params:  "out=gen,mode=per_method",
+			path:    []int32{6, 0, 2, 0},
+			comment: " Echo echoes.\n renamed_from: OldEcho\n",
+			existing: map[string]string{
+				"test_service_handler.go": "package test_v1\n\ntype TestServiceHandler struct{}\n",
+				"test_service_old_echo.go": `package test_v1
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	testv1 "example.com/gen/test/v1"
+)
+
+// OldEcho implements the OldEcho RPC
+func (t *TestServiceHandler) OldEcho(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {
+	// keep me
+	return connect.NewResponse(req.Msg), nil
+}
+`,
+				"test_service_old_echo_test.go": "package test_v1\n\nimport \"testing\"\n\n" +
+					"func TestTestServiceHandler_OldEcho(t *testing.T) {\n\th := NewTestServiceHandler()\n\t_, _ = h.OldEcho(t.Context(), nil)\n}\n",
+				"test_service_handler.state.json": `{"version": 1, "service": "test.v1.TestService", "struct": "TestServiceHandler",
+"methods": [{"rpc": "test.v1.TestService.OldEcho", "method": "OldEcho", "file": "test_service_old_echo.go"}]}`,
+			},
+			check: map[string][]string{
+				"test_service_echo.go": {
+					"// Echo implements the Echo RPC\nfunc (t *TestServiceHandler) Echo(",
+					"\t// keep me\n",
+				},
+				"test_service_old_echo.go": {
+					"package test_v1\n\n// OldEcho was renamed to Echo and moved to test_service_echo.go.\n",
+				},
+				"test_service_echo_test.go": {
+					"func TestTestServiceHandler_Echo(t *testing.T) {\n\th := NewTestServiceHandler()\n\t_, _ = h.Echo(t.Context(), nil)\n}\n",
+				},
+				"test_service_old_echo_test.go": {
+					"package test_v1\n\n// The tests of OldEcho were renamed to Echo and moved to test_service_echo_test.go.\n",
+				},
+				"test_service_handler.state.json": {
+					`"rpc": "test.v1.TestService.Echo",
+      "method": "Echo",
+      "file": "test_service_echo.go"`,
+				},
+			},
+		},
+		{
+			name:    "service",
+			params:  "out=gen",
+			path:    []int32{6, 0},
+			comment: " renamed_from: test.v1.OldService\n",
+			existing: map[string]string{
+				"old_service_handler.go": `package test_v1
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	testv1 "example.com/gen/test/v1"
+)
+
+type OldServiceHandler struct{}
+
+func NewOldServiceHandler() *OldServiceHandler {
+	return &OldServiceHandler{}
+}
+
+// Echo implements the Echo RPC
+func (t *OldServiceHandler) Echo(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {
+	// keep me
+	return connect.NewResponse(req.Msg), nil
+}
+`,
+				"old_service_handler.gen.go": "// Code generated by protoc-gen-connect-go-handler. DO NOT EDIT.\n\n" +
+					"package test_v1\n\nvar _ OldServiceServer = (*OldServiceHandler)(nil)\n",
+				"wire.go": "package test_v1\n\nvar handler = NewOldServiceHandler()\n",
+				"old_service_echo_test.go": "package test_v1\n\nimport \"testing\"\n\n" +
+					"func TestOldServiceHandler_Echo(t *testing.T) {\n\t_, _ = NewOldServiceHandler().Echo(t.Context(), nil)\n}\n",
+			},
+			check: map[string][]string{
+				"test_service_handler.go": {
+					"type TestServiceHandler struct{}\n",
+					"func NewTestServiceHandler() *TestServiceHandler {\n\treturn &TestServiceHandler{}\n}",
+					"func (t *TestServiceHandler) Echo(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {\n\t// keep me\n",
+					"func (t *TestServiceHandler) Ping(",
+				},
+				"old_service_handler.go": {
+					"// Service OldService was renamed to TestService and this file moved to test_service_handler.go.\n",
+				},
+				"old_service_handler.gen.go": {
+					"DO NOT EDIT.\n\npackage test_v1\n\n// Service OldService was renamed to TestService;",
+				},
+				"wire.go": {"var handler = NewTestServiceHandler()\n"},
+				"test_service_echo_test.go": {
+					"func TestTestServiceHandler_Echo(t *testing.T) {\n\t_, _ = NewTestServiceHandler().Echo(t.Context(), nil)\n}\n",
+				},
+				"old_service_echo_test.go": {
+					"// Service OldService was renamed to TestService and this file moved to test_service_echo_test.go.\n",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			writeTestFiles(t, "gen", tt.existing)
+
+			var logs bytes.Buffer
+			prev := logOutput
+			logOutput = &logs
+			t.Cleanup(func() { logOutput = prev })
+
+			resp, err := Generate(withComment(testRequestWithServices(tt.params), tt.path, tt.comment))
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			if strings.Contains(logs.String(), "no matching RPC") {
+				t.Errorf("renamed method reported as orphan:\n%s", logs.String())
+			}
+			if !strings.Contains(logs.String(), "protoc-gen-connect-go-handler: gen/") || strings.Contains(logs.String(), "warning: gen/") {
+				t.Errorf("renames should be reported without a warning:\n%s", logs.String())
+			}
+
+			files := make(map[string]string)
+			for _, file := range resp.File {
+				files[file.GetName()] = file.GetContent()
+			}
+			for name, substrings := range tt.check {
+				content, ok := files[name]
+				if !ok {
+					t.Errorf("expected file %s to be generated", name)
+					continue
+				}
+				for _, substr := range substrings {
+					if !strings.Contains(content, substr) {
+						t.Errorf("%s should contain %q\n%s", name, substr, content)
+					}
+				}
+			}
+			if strings.Count(files["test_service_handler.go"], ") Echo(") > 1 {
+				t.Errorf("duplicate Echo method\n%s", files["test_service_handler.go"])
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package generator
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// scaffoldFileName is the file server_scaffold creates
+const scaffoldFileName = "main.go"
+
+// scaffoldImports are the standard library packages the server scaffold uses
+var scaffoldImports = []string{"context", "errors", "flag", "log", "net/http", "os", "os/signal", "syscall", "time"}
+
+// generateServerScaffold creates a main package serving every service of the
+// run. Like the struct file it is created once and never overwritten, so it
+// is yours to edit from then on.
+func generateServerScaffold(r *registry, idx *typeIndex, out *outputSet, opts *Options) error {
+	path := filepath.Join(opts.ServerScaffold, scaffoldFileName)
+	if len(r.services) == 0 || out.exists(path) {
+		return nil
+	}
+
+	data, err := r.context("main", "", opts)
+	if err != nil {
+		return err
+	}
+	for _, importPath := range scaffoldImports {
+		data.Imports.Add(importPath, "")
+	}
+
+	// Mount the services through the registry when there is one, so the
+	// server picks up health checking and reflection as well
+	if opts.RegistryOut != "" {
+		packageName, importPath, err := r.registryPackage(opts)
+		if err != nil {
+			return err
+		}
+		if importPath == "" {
+			return fmt.Errorf("server_scaffold: cannot determine the import path of %s; add a go.mod",
+				filepath.Join(opts.Out, opts.RegistryOut))
+		}
+		data.Registry = data.Imports.Add(importPath, packageName)
+	}
+
+	content, err := renderRegistryTemplate(TEMPLATE_SERVER, data, idx, opts)
+	if err != nil {
+		return fmt.Errorf("failed to render server scaffold: %w", err)
+	}
+	out.write(path, content)
+	return nil
+}
@@ -0,0 +1,93 @@
+package generator
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGenerateServerScaffold(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("go.mod", []byte("module example.com/app\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := Generate(testRequestWithServices("out=gen,dir_pattern={service_snake},server_scaffold=cmd/server"))
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range resp.File {
+		files[f.GetName()] = f.GetContent()
+	}
+
+	content, ok := files["cmd/server/main.go"]
+	if !ok {
+		t.Fatalf("main.go not generated, got %v", resp.File)
+	}
+	for _, substr := range []string{
+		"// Command server serves the Connect handlers of test.v1.TestService, test.v1.EmptyService\n",
+		"package main\n",
+		"\ttest_v1 \"example.com/app/gen/test_service\"\n",
+		"\ttest_v1.RegisterTestService(mux, test_v1.NewTestServiceHandler())\n",
+		"\ttest_v11.RegisterEmptyService(mux, test_v11.NewEmptyServiceHandler())\n",
+		"protocols.SetUnencryptedHTTP2(true)",
+		"signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)",
+		"srv.Shutdown(shutdownCtx)",
+	} {
+		if !strings.Contains(content, substr) {
+			t.Errorf("main.go should contain %q\n%s", substr, content)
+		}
+	}
+	if _, ok := files["test_service/test_service_handler_register.gen.go"]; !ok {
+		t.Error("server_scaffold should generate the register files it calls")
+	}
+}
+
+func TestGenerateServerScaffoldKeepsExisting(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("go.mod", []byte("module example.com/app\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, "gen/cmd/server", map[string]string{"main.go": "package main\n\nfunc main() {}\n"})
+
+	resp, err := Generate(testRequestWithServices("out=gen,server_scaffold=cmd/server"))
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	for _, f := range resp.File {
+		if f.GetName() == "cmd/server/main.go" {
+			t.Errorf("existing main.go overwritten:\n%s", f.GetContent())
+		}
+	}
+}
+
+func TestGenerateServerScaffoldWithRegistry(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("go.mod", []byte("module example.com/app\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := Generate(testRequestWithServices("out=gen,dir_pattern={service_snake},registry_out=registry,health=true,server_scaffold=cmd/server"))
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	for _, f := range resp.File {
+		if f.GetName() != "cmd/server/main.go" {
+			continue
+		}
+		for _, substr := range []string{
+			"\t\"example.com/app/gen/registry\"\n",
+			"\tregistry.RegisterAll(mux, registry.NewHandlers(\n" +
+				"\t\ttest_v1.NewTestServiceHandler(),\n" +
+				"\t\ttest_v11.NewEmptyServiceHandler(),\n" +
+				"\t))\n",
+		} {
+			if !strings.Contains(f.GetContent(), substr) {
+				t.Errorf("main.go should contain %q\n%s", substr, f.GetContent())
+			}
+		}
+		return
+	}
+	t.Fatalf("main.go not generated, got %v", resp.File)
+}
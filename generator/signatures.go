@@ -0,0 +1,249 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// parsedSource is Go source along with the positions of its parsed nodes
+type parsedSource struct {
+	src     string
+	tokFile *token.File
+}
+
+// offset returns the byte offset of pos in the source
+func (p parsedSource) offset(pos token.Pos) int {
+	return p.tokFile.Offset(pos)
+}
+
+// text returns the source of node
+func (p parsedSource) text(node ast.Node) string {
+	return p.src[p.offset(node.Pos()):p.offset(node.End())]
+}
+
+// checkSignatures compares the methods implementing the service's RPCs with
+// the signatures the method template generates for them. Mismatches are
+// reported on stderr or, with fix_signatures, rewritten in place.
+func checkSignatures(fileDesc *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto, ctx Context, idx *typeIndex, pkg *packageIndex, out *outputSet, opts *Options) error {
+	var files []string
+	byFile := make(map[string][]*descriptorpb.MethodDescriptorProto)
+	for _, method := range svc.GetMethod() {
+		loc, ok := pkg.lookup(ctx.StructName, method.GetName())
+		if !ok || pkg.broken[loc.File] {
+			continue
+		}
+		if _, ok := byFile[loc.File]; !ok {
+			files = append(files, loc.File)
+		}
+		byFile[loc.File] = append(byFile[loc.File], method)
+	}
+
+	for _, name := range files {
+		if err := checkFileSignatures(fileDesc, svc, filepath.Join(ctx.Dir, name), byFile[name], ctx, idx, out, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFileSignatures checks the methods of one file
+func checkFileSignatures(fileDesc *descriptorpb.FileDescriptorProto, svc *descriptorpb.ServiceDescriptorProto, path string, methods []*descriptorpb.MethodDescriptorProto, ctx Context, idx *typeIndex, out *outputSet, opts *Options) error {
+	src, _, err := out.read(path)
+	if err != nil {
+		return err
+	}
+
+	origin := fmt.Sprintf("signature check of %s", path)
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		return invalidGoError(src, origin, 0, err)
+	}
+	have := parsedSource{src: src, tokFile: fset.File(file.Pos())}
+
+	// Expected types use the local names the file imports packages by
+	fileImports := importsForFile(file, ctx.Imports)
+	decls := methodDecls(file, ctx.StructName)
+
+	var edits []textEdit
+	var needed []Import
+	for _, method := range methods {
+		decl, ok := decls[method.GetName()]
+		if !ok {
+			continue
+		}
+
+		want, wantType, err := expectedSignature(method, ctx, idx, fileImports)
+		if err != nil {
+			return err
+		}
+		diffs := signatureDiffs(decl.Type, wantType)
+		if len(diffs) == 0 {
+			continue
+		}
+
+		position := fmt.Sprintf("%s:%d", filepath.Join(opts.Out, path), fset.Position(decl.Pos()).Line)
+		rpc := qualifyProtoName(fileDesc.GetPackage(), svc.GetName()) + "." + method.GetName()
+		if !opts.FixSignatures {
+			warnf("%s: %s.%s does not match RPC %s: %s; set fix_signatures=true to rewrite it",
+				position, ctx.StructName, method.GetName(), rpc, strings.Join(diffs, "; "))
+			continue
+		}
+
+		infof("%s: rewrote the signature of %s.%s to match RPC %s: %s",
+			position, ctx.StructName, method.GetName(), rpc, strings.Join(diffs, "; "))
+		edits = append(edits, fieldListEdits(have, decl.Type.Params, decl.Type.Params.End(), want, wantType.Params)...)
+		edits = append(edits, fieldListEdits(have, decl.Type.Results, decl.Type.Params.End(), want, wantType.Results)...)
+		needed = append(needed, importsReferencedBy(wantType, fileImports)...)
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+
+	edits = append(edits, importEdits(have.tokFile, file, src, missingImports(file, fileImports, needed))...)
+	content, err := finalizeGoFile(applyEdits(src, edits), fileImports, origin)
+	if err != nil {
+		return err
+	}
+	out.write(path, content)
+	return nil
+}
+
+// expectedSignature renders the method template for an RPC and returns the
+// type of the function it declares, with types qualified through imports
+func expectedSignature(method *descriptorpb.MethodDescriptorProto, ctx Context, idx *typeIndex, imports *Imports) (parsedSource, *ast.FuncType, error) {
+	methodCtx := ctx
+	methodCtx.Imports = imports
+	methodCtx.Method = newMethodContext(method, idx, imports)
+
+	content, err := renderTemplate(TEMPLATE_METHOD_ONLY, methodCtx)
+	if err != nil {
+		return parsedSource{}, nil, fmt.Errorf("failed to render method template: %w", err)
+	}
+	origin := templateOrigin(TEMPLATE_METHOD_ONLY, methodCtx)
+	if err := validateGoDecls(content, origin); err != nil {
+		return parsedSource{}, nil, err
+	}
+
+	src := "package p\n\n" + content
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		return parsedSource{}, nil, invalidGoError(src, origin, 2, err)
+	}
+	for _, decl := range file.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Name.Name == method.GetName() {
+			return parsedSource{src: src, tokFile: fset.File(file.Pos())}, funcDecl.Type, nil
+		}
+	}
+	return parsedSource{}, nil, fmt.Errorf("%s does not declare method %s", origin, method.GetName())
+}
+
+// signatureDiffs describes how the parameter and result types of have
+// differ from those of want
+func signatureDiffs(have, want *ast.FuncType) []string {
+	diffs := fieldListDiffs("parameter", fieldTypes(have.Params), fieldTypes(want.Params))
+	return append(diffs, fieldListDiffs("result", fieldTypes(have.Results), fieldTypes(want.Results))...)
+}
+
+// fieldListDiffs compares two lists of types
+func fieldListDiffs(kind string, have, want []ast.Expr) []string {
+	if len(have) != len(want) {
+		return []string{fmt.Sprintf("%ss are (%s), want (%s)", kind, typeList(have), typeList(want))}
+	}
+
+	var diffs []string
+	for i := range have {
+		if types.ExprString(have[i]) != types.ExprString(want[i]) {
+			diffs = append(diffs, fmt.Sprintf("%s %d is %s, want %s",
+				kind, i+1, types.ExprString(have[i]), types.ExprString(want[i])))
+		}
+	}
+	return diffs
+}
+
+// fieldTypes returns the type of each parameter or result in list, repeating
+// the type of fields that declare several names
+func fieldTypes(list *ast.FieldList) []ast.Expr {
+	if list == nil {
+		return nil
+	}
+
+	var exprs []ast.Expr
+	for _, field := range list.List {
+		for range max(1, len(field.Names)) {
+			exprs = append(exprs, field.Type)
+		}
+	}
+	return exprs
+}
+
+// typeList formats types as a comma-separated list
+func typeList(exprs []ast.Expr) string {
+	names := make([]string, len(exprs))
+	for i, expr := range exprs {
+		names[i] = types.ExprString(expr)
+	}
+	return strings.Join(names, ", ")
+}
+
+// fieldListEdits returns edits giving the field list haveList the types of
+// wantList. Types are replaced one field at a time so parameter names
+// survive; when the lists differ in shape the whole list is replaced. A
+// missing haveList is inserted at after.
+func fieldListEdits(have parsedSource, haveList *ast.FieldList, after token.Pos, want parsedSource, wantList *ast.FieldList) []textEdit {
+	if edits, ok := fieldTypeEdits(have, haveList, want, wantList); ok {
+		return edits
+	}
+
+	var wantText string
+	if wantList != nil {
+		wantText = want.text(wantList)
+	}
+	if haveList == nil {
+		return []textEdit{{offset: have.offset(after), text: " " + wantText}}
+	}
+	return []textEdit{{offset: have.offset(haveList.Pos()), end: have.offset(haveList.End()), text: wantText}}
+}
+
+// fieldTypeEdits replaces the types of the fields in haveList one by one. It
+// reports false if the lists cannot be matched field by field.
+func fieldTypeEdits(have parsedSource, haveList *ast.FieldList, want parsedSource, wantList *ast.FieldList) ([]textEdit, bool) {
+	wantTypes := fieldTypes(wantList)
+	if len(fieldTypes(haveList)) != len(wantTypes) {
+		return nil, false
+	}
+	if haveList == nil {
+		return nil, true
+	}
+
+	var edits []textEdit
+	i := 0
+	for _, field := range haveList.List {
+		n := max(1, len(field.Names))
+		target := wantTypes[i]
+		for _, expr := range wantTypes[i : i+n] {
+			// Names sharing a type cannot be given different ones
+			if types.ExprString(expr) != types.ExprString(target) {
+				return nil, false
+			}
+		}
+		i += n
+
+		if types.ExprString(field.Type) != types.ExprString(target) {
+			edits = append(edits, textEdit{
+				offset: have.offset(field.Type.Pos()),
+				end:    have.offset(field.Type.End()),
+				text:   want.text(target),
+			})
+		}
+	}
+	return edits, true
+}
@@ -0,0 +1,138 @@
+package generator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGenerateSignatureMismatch(t *testing.T) {
+	handlerFile := `package test_v1
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	testv1 "example.com/gen/test/v1"
+)
+
+type TestServiceHandler struct{}
+
+// Echo still uses the old request type
+func (t *TestServiceHandler) Echo(ctx context.Context, req *connect.Request[testv1.OldEchoRequest]) (*connect.Response[testv1.EchoRequest], error) {
+	// keep me
+	return connect.NewResponse(&testv1.EchoRequest{}), nil
+}
+`
+	pingFile := `package test_v1
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Ping used to be server streaming
+func (t *TestServiceHandler) Ping(ctx context.Context, req *connect.Request[emptypb.Empty], stream *connect.ServerStream[emptypb.Empty]) error {
+	return nil
+}
+`
+
+	tests := []struct {
+		name     string
+		params   string
+		warnings []string
+		check    map[string][]string // response file -> expected substrings
+		absent   []string            // response files that must not be emitted
+	}{
+		{
+			name:   "report",
+			params: "out=gen",
+			warnings: []string{
+				"gen/test_service_handler.go:13: TestServiceHandler.Echo does not match RPC test.v1.TestService.Echo: " +
+					"parameter 2 is *connect.Request[testv1.OldEchoRequest], want *connect.Request[testv1.EchoRequest]; " +
+					"set fix_signatures=true to rewrite it",
+				"gen/ping_impl.go:11: TestServiceHandler.Ping does not match RPC test.v1.TestService.Ping: " +
+					"parameters are (context.Context, *connect.Request[emptypb.Empty], *connect.ServerStream[emptypb.Empty]), " +
+					"want (context.Context, *connect.Request[emptypb.Empty]); " +
+					"results are (error), want (*connect.Response[commonv1.Status], error)",
+			},
+			check: map[string][]string{
+				"test_service_handler.go": {"req *connect.Request[testv1.OldEchoRequest]"},
+			},
+			absent: []string{"ping_impl.go"},
+		},
+		{
+			name:   "fix",
+			params: "out=gen,fix_signatures=true",
+			warnings: []string{
+				"gen/test_service_handler.go:13: rewrote the signature of TestServiceHandler.Echo to match RPC test.v1.TestService.Echo",
+				"gen/ping_impl.go:11: rewrote the signature of TestServiceHandler.Ping to match RPC test.v1.TestService.Ping",
+			},
+			check: map[string][]string{
+				"test_service_handler.go": {
+					"func (t *TestServiceHandler) Echo(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {\n" +
+						"\t// keep me\n" +
+						"\treturn connect.NewResponse(&testv1.EchoRequest{}), nil\n}",
+				},
+				"ping_impl.go": {
+					"\tcommonv1 \"example.com/gen/common/v1\"\n",
+					"func (t *TestServiceHandler) Ping(\n" +
+						"\tctx context.Context,\n" +
+						"\treq *connect.Request[emptypb.Empty],\n" +
+						") (*connect.Response[commonv1.Status], error) {\n" +
+						"\treturn nil\n}",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			writeTestFiles(t, "gen", map[string]string{
+				"test_service_handler.go": handlerFile,
+				"ping_impl.go":            pingFile,
+			})
+
+			var logs bytes.Buffer
+			prev := logOutput
+			logOutput = &logs
+			t.Cleanup(func() { logOutput = prev })
+
+			resp, err := Generate(testRequestWithServices(tt.params))
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+
+			for _, warning := range tt.warnings {
+				if !strings.Contains(logs.String(), warning) {
+					t.Errorf("expected warning %q, got:\n%s", warning, logs.String())
+				}
+			}
+
+			files := make(map[string]string)
+			for _, file := range resp.File {
+				files[file.GetName()] = file.GetContent()
+			}
+			for name, substrings := range tt.check {
+				content, ok := files[name]
+				if !ok {
+					t.Errorf("expected file %s to be generated", name)
+					continue
+				}
+				for _, substr := range substrings {
+					if !strings.Contains(content, substr) {
+						t.Errorf("%s should contain %q\n%s", name, substr, content)
+					}
+				}
+			}
+			for _, name := range tt.absent {
+				if _, ok := files[name]; ok {
+					t.Errorf("unexpected file %s\n%s", name, files[name])
+				}
+			}
+		})
+	}
+}
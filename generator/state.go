@@ -0,0 +1,107 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// stateVersion is the version of the state file format
+const stateVersion = 1
+
+// serviceState is the content of the state file written next to a service's
+// manifest. It records where the Go implementation of each RPC lives, so
+// later runs can follow renames.
+type serviceState struct {
+	Version   int           `json:"version"`
+	Service   string        `json:"service"`              // proto full name, e.g. "test.v1.TestService"
+	Struct    string        `json:"struct"`               // handler struct, e.g. "TestServiceHandler"
+	RenamedTo string        `json:"renamed_to,omitempty"` // proto full name of the service it was renamed to
+	Methods   []methodState `json:"methods,omitempty"`
+}
+
+// methodState records the Go implementation of one RPC
+type methodState struct {
+	RPC    string `json:"rpc"`            // proto full name, e.g. "test.v1.TestService.Echo"
+	Method string `json:"method"`         // Go method name
+	File   string `json:"file,omitempty"` // file declaring the method, relative to the handler directory
+	Stub   string `json:"stub,omitempty"` // stubHash of the generated method, kept until it is edited
+
+	// Summary is the first paragraph of the method's doc comment as the
+	// generator last wrote it with sync_docs, kept until it is edited
+	Summary string `json:"summary,omitempty"`
+
+	// Doc is the part of the method's doc comment the generator last wrote
+	// with sync_docs: the proto comments after the summary, the Proto line
+	// and what follows it. Lines above and below it are the developer's.
+	Doc string `json:"doc,omitempty"`
+}
+
+// readState reads a state file, returning nil if it does not exist
+func readState(out *outputSet, path string) (*serviceState, error) {
+	content, exists, err := out.read(path)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	var state serviceState
+	if err := json.Unmarshal([]byte(content), &state); err != nil {
+		return nil, fmt.Errorf("%s: invalid state file: %w", path, err)
+	}
+	return &state, nil
+}
+
+// lookup returns the recorded implementation of an RPC
+func (s *serviceState) lookup(rpc string) (methodState, bool) {
+	if s == nil {
+		return methodState{}, false
+	}
+	for _, method := range s.Methods {
+		if method.RPC == rpc {
+			return method, true
+		}
+	}
+	return methodState{}, false
+}
+
+// writeState records where each RPC of the service is implemented after
+// this run, along with the hash of each stub and the doc summary of each
+// method nobody has edited yet
+func writeState(svc *descriptorpb.ServiceDescriptorProto, ctx Context, pkg *packageIndex, out *outputSet) error {
+	state := serviceState{
+		Version: stateVersion,
+		Service: ctx.Service.FullName,
+		Struct:  ctx.StructName,
+	}
+	for _, method := range svc.GetMethod() {
+		entry := methodState{
+			RPC:    ctx.Service.FullName + "." + method.GetName(),
+			Method: method.GetName(),
+		}
+		if loc, ok := pkg.lookup(ctx.StructName, method.GetName()); ok {
+			entry.File = loc.File
+			entry.Summary = loc.Summary
+			entry.Doc = loc.Doc
+			if loc.Generated {
+				parsed, _, decl, err := parseMethod(out, filepath.Join(ctx.Dir, loc.File), ctx.StructName, method.GetName())
+				if err != nil {
+					return err
+				}
+				if decl != nil {
+					entry.Stub = stubHash(methodSource(parsed, decl))
+				}
+			}
+		}
+		state.Methods = append(state.Methods, entry)
+	}
+	out.write(ctx.StatePath, marshalState(state))
+	return nil
+}
+
+// marshalState encodes a state file
+func marshalState(state serviceState) string {
+	data, _ := json.MarshalIndent(state, "", "  ")
+	return string(data) + "\n"
+}
@@ -0,0 +1,134 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// stubHash fingerprints the source of a generated method declaration, doc
+// comment included, as it was emitted
+func stubHash(decl string) string {
+	sum := sha256.Sum256([]byte(decl))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseMethod parses the file at path and returns the declaration of a
+// method of structName, or nil if the file does not declare it
+func parseMethod(out *outputSet, path, structName, methodName string) (parsedSource, *ast.File, *ast.FuncDecl, error) {
+	src, _, err := out.read(path)
+	if err != nil {
+		return parsedSource{}, nil, nil, err
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		return parsedSource{}, nil, nil, invalidGoError(src, path, 0, err)
+	}
+	parsed := parsedSource{src: src, tokFile: fset.File(file.Pos())}
+	return parsed, file, methodDecls(file, structName)[methodName], nil
+}
+
+// methodSource returns the source of a method declaration with its doc comment
+func methodSource(parsed parsedSource, decl *ast.FuncDecl) string {
+	start := decl.Pos()
+	if decl.Doc != nil {
+		start = decl.Doc.Pos()
+	}
+	return parsed.src[parsed.offset(start):parsed.offset(decl.End())]
+}
+
+// refreshStubs regenerates the method stubs whose source still hashes to
+// what the state file recorded when they were generated, so template and
+// signature changes reach stubs nobody has edited. Edited methods no longer
+// match their hash and are left alone.
+func refreshStubs(svc *descriptorpb.ServiceDescriptorProto, ctx Context, idx *typeIndex, pkg *packageIndex, state *serviceState, out *outputSet, opts *Options) error {
+	for _, method := range svc.GetMethod() {
+		entry, ok := state.lookup(ctx.Service.FullName + "." + method.GetName())
+		if !ok || entry.Stub == "" {
+			continue
+		}
+		loc, ok := pkg.lookup(ctx.StructName, method.GetName())
+		if !ok || pkg.broken[loc.File] {
+			continue
+		}
+
+		path := filepath.Join(ctx.Dir, loc.File)
+		parsed, file, decl, err := parseMethod(out, path, ctx.StructName, method.GetName())
+		if err != nil {
+			return err
+		}
+		if decl == nil || stubHash(methodSource(parsed, decl)) != entry.Stub {
+			continue
+		}
+
+		var content string
+		if opts.Mode == modePerMethod && loc.File == methodFileName(svc.GetName(), method.GetName()) &&
+			onlyHoldsMethods(parsed.src, ctx.StructName, map[string]bool{method.GetName(): true}) {
+			// A per-method file holding nothing else is regenerated whole
+			methodCtx := ctx
+			methodCtx.Method = newMethodContext(method, idx, ctx.Imports)
+			methodCtx.MethodPath = path
+			content, err = renderGoFile(TEMPLATE_METHOD, methodCtx)
+		} else {
+			content, err = replaceMethod(parsed, file, method, ctx, idx, path)
+		}
+		if err != nil {
+			return err
+		}
+
+		if content != parsed.src {
+			out.write(path, content)
+			infof("%s:%d: regenerated untouched stub %s.%s", filepath.Join(opts.Out, path), loc.Line, ctx.StructName, method.GetName())
+		}
+		loc.Generated = true
+		pkg.add(ctx.StructName, method.GetName(), loc)
+	}
+	return nil
+}
+
+// replaceMethod replaces a method declaration in its file with a freshly
+// rendered stub, adding the imports the stub needs
+func replaceMethod(parsed parsedSource, file *ast.File, method *descriptorpb.MethodDescriptorProto, ctx Context, idx *typeIndex, path string) (string, error) {
+	fileImports := importsForFile(file, ctx.Imports)
+	methodCtx := ctx
+	methodCtx.Imports = fileImports
+	methodCtx.Method = newMethodContext(method, idx, fileImports)
+
+	methodContent, err := renderTemplate(TEMPLATE_METHOD_ONLY, methodCtx)
+	if err != nil {
+		return "", fmt.Errorf("failed to render method template: %w", err)
+	}
+	if err := validateGoDecls(methodContent, templateOrigin(TEMPLATE_METHOD_ONLY, methodCtx)); err != nil {
+		return "", err
+	}
+	origin := fmt.Sprintf("refreshed stub %s in %s", method.GetName(), path)
+
+	edit, err := newASTEdit(parsed.src)
+	if err != nil {
+		return "", invalidGoError(parsed.src, path, 0, err)
+	}
+	if err := edit.replaceDecl(methodDecls(edit.file, ctx.StructName)[method.GetName()], methodContent); err != nil {
+		return "", fmt.Errorf("%s: %w", origin, err)
+	}
+	used, err := importsUsedBy(methodContent, fileImports)
+	if err != nil {
+		return "", err
+	}
+	if err := edit.addImports(missingImports(edit.file, fileImports, used)); err != nil {
+		return "", err
+	}
+
+	content, err := edit.print()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", origin, err)
+	}
+	return finalizeGoFile(content, fileImports, origin)
+}
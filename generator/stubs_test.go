@@ -0,0 +1,166 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateRefreshesUntouchedStubs(t *testing.T) {
+	// An older template generated these stubs
+	oldEcho := `// Echo implements the Echo RPC
+func (t *TestServiceHandler) Echo(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {
+	return nil, nil
+}`
+	oldPing := `// Ping implements the Ping RPC
+func (t *TestServiceHandler) Ping(ctx context.Context, req *connect.Request[emptypb.Empty]) (*connect.Response[commonv1.Status], error) {
+	return nil, nil
+}`
+	editedPing := strings.Replace(oldPing, "{\n", "{\n\t// edited\n", 1)
+	header := `package test_v1
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	commonv1 "example.com/gen/common/v1"
+	testv1 "example.com/gen/test/v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+`
+
+	tests := []struct {
+		name      string
+		params    string
+		existing  map[string]string
+		stubFile  string            // file recorded for both stubs, per-method files if empty
+		refreshed map[string]string // response file -> expected substring
+		kept      map[string]string // file -> edited code that must survive
+	}{
+		{
+			name:   "per_service",
+			params: "out=gen",
+			existing: map[string]string{
+				"test_service_handler.go": header + "\ntype TestServiceHandler struct{}\n\n" + oldEcho + "\n\n" + editedPing + "\n",
+			},
+			stubFile:  "test_service_handler.go",
+			refreshed: map[string]string{"test_service_handler.go": "connect.CodeUnimplemented"},
+			kept:      map[string]string{"test_service_handler.go": editedPing},
+		},
+		{
+			name:   "per_method",
+			params: "out=gen,mode=per_method",
+			existing: map[string]string{
+				"test_service_handler.go": "package test_v1\n\ntype TestServiceHandler struct{}\n",
+				"test_service_echo.go":    header + "\n" + oldEcho + "\n",
+				"test_service_ping.go":    header + "\n" + editedPing + "\n",
+			},
+			refreshed: map[string]string{"test_service_echo.go": "connect.CodeUnimplemented"},
+			kept:      map[string]string{"test_service_ping.go": editedPing},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+
+			fileOf := func(method string) string {
+				if tt.stubFile != "" {
+					return tt.stubFile
+				}
+				return methodFileName("TestService", method)
+			}
+
+			// Ping was edited since it was generated, Echo was not
+			existing := tt.existing
+			existing["test_service_handler.state.json"] = marshalState(serviceState{
+				Version: stateVersion,
+				Service: "test.v1.TestService",
+				Struct:  "TestServiceHandler",
+				Methods: []methodState{
+					{RPC: "test.v1.TestService.Echo", Method: "Echo", File: fileOf("Echo"), Stub: stubHash(oldEcho)},
+					{RPC: "test.v1.TestService.Ping", Method: "Ping", File: fileOf("Ping"), Stub: stubHash(oldPing)},
+				},
+			})
+			writeTestFiles(t, "gen", existing)
+
+			var logs bytes.Buffer
+			prev := logOutput
+			logOutput = &logs
+			t.Cleanup(func() { logOutput = prev })
+
+			resp, err := Generate(testRequestWithServices(tt.params))
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			files := make(map[string]string)
+			for _, f := range resp.File {
+				files[f.GetName()] = f.GetContent()
+			}
+
+			for name, substr := range tt.refreshed {
+				if !strings.Contains(files[name], substr) {
+					t.Errorf("%s should have been refreshed to contain %q\n%s", name, substr, files[name])
+				}
+			}
+			for name, substr := range tt.kept {
+				content, ok := files[name]
+				if !ok {
+					data, _ := os.ReadFile(filepath.Join("gen", name))
+					content = string(data)
+				}
+				if !strings.Contains(content, substr) {
+					t.Errorf("%s should keep %q\n%s", name, substr, content)
+				}
+			}
+			if !strings.Contains(logs.String(), "regenerated untouched stub TestServiceHandler.Echo") {
+				t.Errorf("refresh not reported:\n%s", logs.String())
+			}
+			if strings.Contains(logs.String(), "TestServiceHandler.Ping") {
+				t.Errorf("edited stub reported as refreshed:\n%s", logs.String())
+			}
+
+			// The refreshed stub stays tracked, the edited one no longer is
+			var state serviceState
+			if err := json.Unmarshal([]byte(files["test_service_handler.state.json"]), &state); err != nil {
+				t.Fatal(err)
+			}
+			echo, _ := state.lookup("test.v1.TestService.Echo")
+			ping, _ := state.lookup("test.v1.TestService.Ping")
+			if echo.Stub == "" || echo.Stub == stubHash(oldEcho) {
+				t.Errorf("Echo stub hash = %q, want the hash of the refreshed stub", echo.Stub)
+			}
+			if ping.Stub != "" {
+				t.Errorf("Ping stub hash = %q, want none", ping.Stub)
+			}
+		})
+	}
+}
+
+func TestGenerateRecordsStubHashes(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	resp, err := Generate(testRequestWithServices("out=gen,mode=per_method"))
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	for _, f := range resp.File {
+		if f.GetName() != "test_service_handler.state.json" {
+			continue
+		}
+		var state serviceState
+		if err := json.Unmarshal([]byte(f.GetContent()), &state); err != nil {
+			t.Fatal(err)
+		}
+		for _, method := range state.Methods {
+			if method.Stub == "" {
+				t.Errorf("%s: no stub hash recorded for a freshly generated stub", method.RPC)
+			}
+		}
+		return
+	}
+	t.Fatal("state file not generated")
+}
@@ -4,47 +4,160 @@ import (
 	"bytes"
 	"embed"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"text/template"
 )
 
 //go:embed templates/*.tmpl
 var templateFS embed.FS
 
-// templateCache holds parsed templates
+// templateCache holds the parsed template sets by template directory
 var templateCache = make(map[string]*template.Template)
 
-// renderTemplate renders a template with the given context
+// renderTemplate renders a template with the given context. importAlias
+// registers imports as the template runs, after a whole file has printed its
+// import block, so a template that adds imports is rendered a second time
+// with them in place.
 func renderTemplate(templateName string, ctx Context) (string, error) {
-	tmpl, err := getTemplate(templateName)
+	known := ctx.Imports.size()
+	content, err := executeTemplate(templateName, ctx, ctx)
+	if err != nil || ctx.Imports.size() == known {
+		return content, err
+	}
+	return executeTemplate(templateName, ctx, ctx)
+}
+
+// executeTemplate renders a template of the set ctx uses with data as dot,
+// e.g. a partial documenting a single method
+func executeTemplate(templateName string, ctx Context, data any) (string, error) {
+	tmpl, err := getTemplate(ctx.TemplateDir, templateName)
 	if err != nil {
 		return "", fmt.Errorf("failed to get template %s: %w", templateName, err)
 	}
 
+	// The cached set is shared by every run, so this run's functions are
+	// bound to a copy of it
+	tmpl, err = tmpl.Clone()
+	if err != nil {
+		return "", fmt.Errorf("failed to copy template %s: %w", templateName, err)
+	}
+	tmpl.Funcs(templateFuncs(ctx.types))
+
 	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, ctx); err != nil {
+	if err := tmpl.Execute(&buf, data); err != nil {
 		return "", fmt.Errorf("failed to execute template %s: %w", templateName, err)
 	}
 
 	return buf.String(), nil
 }
 
-// getTemplate retrieves a template from cache or loads it
-func getTemplate(name string) (*template.Template, error) {
-	if tmpl, exists := templateCache[name]; exists {
-		return tmpl, nil
+// renderGoFile renders a template producing a complete Go file, drops the
+// imports the rendered file does not use and formats it with gofmt
+func renderGoFile(templateName string, ctx Context) (string, error) {
+	content, err := renderTemplate(templateName, ctx)
+	if err != nil {
+		return "", err
 	}
 
-	templatePath := fmt.Sprintf("templates/%s.tmpl", name)
-	content, err := templateFS.ReadFile(templatePath)
+	return finalizeGoFile(content, ctx.Imports, templateOrigin(templateName, ctx))
+}
+
+// finalizeGoFile validates and formats a complete Go file, dropping unused
+// imports managed by imports
+func finalizeGoFile(content string, imports *Imports, origin string) (string, error) {
+	content, err := formatGo(content, origin)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read template file %s: %w", templatePath, err)
+		return "", err
 	}
 
-	tmpl, err := template.New(name).Parse(string(content))
+	content, err = pruneUnusedImports(content, imports)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+		return "", fmt.Errorf("failed to prune imports of %s: %w", origin, err)
+	}
+
+	return formatGo(content, origin)
+}
+
+// templateOrigin describes a rendered template for error messages
+func templateOrigin(templateName string, ctx Context) string {
+	origin := fmt.Sprintf("template %q for service %s", templateName, ctx.Service.Name)
+	if ctx.Method != nil {
+		origin += fmt.Sprintf(" (method %s)", ctx.Method.Name)
+	}
+	return origin
+}
+
+// getTemplate retrieves a template from the set loaded for dir
+func getTemplate(dir, name string) (*template.Template, error) {
+	set, exists := templateCache[dir]
+	if !exists {
+		var err error
+		if set, err = loadTemplates(dir); err != nil {
+			return nil, err
+		}
+		templateCache[dir] = set
 	}
 
-	templateCache[name] = tmpl
+	tmpl := set.Lookup(name)
+	if tmpl == nil {
+		return nil, fmt.Errorf("template %s is not defined", name)
+	}
 	return tmpl, nil
 }
+
+// loadTemplates parses the embedded templates and then every *.tmpl file in
+// dir into a single set, so a file in dir replaces the embedded template of
+// the same name and all files can share partials through {{define}} and
+// {{template}}. Each file defines a template named after it without the
+// .tmpl extension.
+func loadTemplates(dir string) (*template.Template, error) {
+	set := template.New("").Funcs(templateFuncs(nil))
+
+	embedded, err := templateFS.ReadDir("templates")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded templates: %w", err)
+	}
+	for _, entry := range embedded {
+		content, err := templateFS.ReadFile("templates/" + entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template file %s: %w", entry.Name(), err)
+		}
+		if err := parseTemplate(set, entry.Name(), string(content)); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir == "" {
+		return set, nil
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("template_dir %s is not a directory", dir)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template file %s: %w", path, err)
+		}
+		if err := parseTemplate(set, path, string(content)); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// parseTemplate adds the template in file to set
+func parseTemplate(set *template.Template, file, content string) error {
+	name := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+	if _, err := set.New(name).Parse(content); err != nil {
+		return fmt.Errorf("failed to parse template %s: %w", file, err)
+	}
+	return nil
+}
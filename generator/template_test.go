@@ -0,0 +1,300 @@
+package generator
+
+import (
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGenerateTemplateDir(t *testing.T) {
+	templateDir := t.TempDir()
+	writeTestFiles(t, templateDir, map[string]string{
+		// Partials shared by the overridden templates
+		"partials.tmpl": `{{define "license"}}// Copyright Example Corp. All rights reserved.
+{{end}}`,
+		TEMPLATE_STRUCT + ".tmpl": `{{template "license"}}
+package {{.PackageName}}
+
+import "log/slog"
+
+// {{.StructName}} handles {{.Service.Name}} RPCs
+type {{.StructName}} struct {
+	log *slog.Logger
+}
+
+// New{{.StructName}} creates a new {{.StructName}} handler
+func New{{.StructName}}(log *slog.Logger) *{{.StructName}} {
+	return &{{.StructName}}{log: log}
+}
+`,
+		TEMPLATE_METHOD + ".tmpl": `{{template "license"}}
+package {{.PackageName}}
+
+import (
+{{- range .Imports.Std}}
+	{{.}}
+{{- end}}
+{{- range .Imports.Third}}
+	{{.}}
+{{- end}}
+)
+
+{{template "method_only" .}}
+`,
+		TEMPLATE_METHOD_ONLY + ".tmpl": `// {{.Method.Name}} is not implemented yet
+func ({{.Receiver}} *{{.StructName}}) {{.Method.Name}}(ctx context.Context, req *connect.Request[{{.Method.Input}}]) (*connect.Response[{{.Method.Output}}], error) {
+	{{.Receiver}}.log.InfoContext(ctx, "{{.Method.Name}} called")
+	return nil, connect.NewError(connect.CodeUnimplemented, nil)
+}`,
+	})
+
+	t.Chdir(t.TempDir())
+	resp, err := Generate(testRequestWithServices("out=gen,mode=per_method,template_dir=" + templateDir))
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	files := make(map[string]string)
+	for _, file := range resp.File {
+		files[file.GetName()] = file.GetContent()
+	}
+
+	for name, substrings := range map[string][]string{
+		"test_service_handler.go": {
+			"// Copyright Example Corp. All rights reserved.\n\npackage test_v1\n",
+			"type TestServiceHandler struct {\n\tlog *slog.Logger\n}",
+		},
+		"test_service_echo.go": {
+			"// Copyright Example Corp. All rights reserved.\n\npackage test_v1\n",
+			"// Echo is not implemented yet\n",
+			"t.log.InfoContext(ctx, \"Echo called\")",
+		},
+		// The manifest is not overridden and keeps the embedded template
+		"test_service_handler.gen.go": {
+			"// Code generated by protoc-gen-connect-go-handler. DO NOT EDIT.",
+		},
+	} {
+		for _, substr := range substrings {
+			if !strings.Contains(files[name], substr) {
+				t.Errorf("%s should contain %q\n%s", name, substr, files[name])
+			}
+		}
+	}
+}
+
+func TestGenerateWholeFileImportAlias(t *testing.T) {
+	templateDir := t.TempDir()
+	writeTestFiles(t, templateDir, map[string]string{
+		TEMPLATE_STRUCT + ".tmpl": `package {{.PackageName}}
+
+import (
+{{- range .Imports.Std}}
+	{{.}}
+{{- end}}
+{{- range .Imports.Third}}
+	{{.}}
+{{- end}}
+)
+
+// {{.StructName}} handles {{.Service.Name}} RPCs
+type {{.StructName}} struct {
+	log *{{importAlias .Imports "log/slog"}}.Logger
+}
+
+// New{{.StructName}} creates a new {{.StructName}} handler
+func New{{.StructName}}() *{{.StructName}} {
+	return &{{.StructName}}{log: {{importAlias .Imports "log/slog"}}.Default()}
+}
+`,
+	})
+
+	t.Chdir(t.TempDir())
+	resp, err := Generate(testRequestWithServices("out=gen,mode=per_method,template_dir=" + templateDir))
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	var content string
+	for _, file := range resp.File {
+		if file.GetName() == "test_service_handler.go" {
+			content = file.GetContent()
+		}
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), "test_service_handler.go", content, parser.ImportsOnly)
+	if err != nil {
+		t.Fatalf("generated file does not parse: %v\n%s", err, content)
+	}
+	var imports []string
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		imports = append(imports, path)
+	}
+	if len(imports) != 1 || imports[0] != "log/slog" {
+		t.Errorf("imports = %v, want [log/slog]\n%s", imports, content)
+	}
+}
+
+func TestLoadTemplatesErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		dir      string
+		expected string
+	}{
+		{
+			name:     "missing directory",
+			dir:      "does-not-exist",
+			expected: "is not a directory",
+		},
+		{
+			name:     "parse error",
+			files:    map[string]string{"struct_stub.tmpl": "{{.StructName"},
+			expected: "struct_stub.tmpl",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFiles(t, dir, tt.files)
+			if tt.dir != "" {
+				dir = tt.dir
+			}
+			if _, err := loadTemplates(dir); err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("loadTemplates() error = %v, want it to contain %q", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExecuteTemplateKeepsCachedFuncs(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"probe.tmpl": `{{with message "test.v1.EchoRequest"}}{{.Name}}{{end}}`})
+
+	idx := newTypeIndex(testRequestWithServices("").GetProtoFile(), &Options{})
+	got, err := executeTemplate("probe", Context{TemplateDir: dir, types: idx}, nil)
+	if err != nil {
+		t.Fatalf("executeTemplate() failed: %v", err)
+	}
+	if got != "EchoRequest" {
+		t.Errorf("executeTemplate() = %q, want %q", got, "EchoRequest")
+	}
+
+	// The cached set still resolves nothing, so runs do not see each other's types
+	var b strings.Builder
+	if err := templateCache[dir].ExecuteTemplate(&b, "probe", nil); err != nil {
+		t.Fatalf("ExecuteTemplate() failed: %v", err)
+	}
+	if b.String() != "" {
+		t.Errorf("cached template = %q, want it unbound from the run's types", b.String())
+	}
+}
+
+func TestDocTemplates(t *testing.T) {
+	loc := SourceLocation{File: "test/v1/test_service.proto", Line: 12}
+
+	tests := []struct {
+		name     string
+		template string
+		data     any
+		expected string
+	}{
+		{
+			name:     "method without comments",
+			template: "method_doc",
+			data:     &MethodContext{Name: "Ping", FullName: "test.v1.TestService.Ping", Location: loc},
+			expected: "// Ping implements the Ping RPC\n" +
+				"//\n" +
+				"// Proto: test.v1.TestService.Ping (test/v1/test_service.proto:12)",
+		},
+		{
+			name:     "method with comments",
+			template: "method_doc",
+			data: &MethodContext{
+				Name: "Echo", FullName: "test.v1.TestService.Echo", Location: loc,
+				Comments: "Echo returns the input message\n\nIt is documented\nat length.\n\nSee the API guide",
+			},
+			expected: "// Echo returns the input message\n" +
+				"//\n" +
+				"// It is documented\n" +
+				"// at length.\n" +
+				"//\n" +
+				"// See the API guide.\n" +
+				"//\n" +
+				"// Proto: test.v1.TestService.Echo (test/v1/test_service.proto:12)",
+		},
+		{
+			name:     "deprecated method",
+			template: "method_doc",
+			data: &MethodContext{
+				Name: "Echo", FullName: "test.v1.TestService.Echo", Location: loc,
+				Comments: "Echo returns the input message.", Deprecated: true,
+			},
+			expected: "// Echo returns the input message.\n" +
+				"//\n" +
+				"// Proto: test.v1.TestService.Echo (test/v1/test_service.proto:12)\n" +
+				"//\n" +
+				"// Deprecated: RPC deprecated in test/v1/test_service.proto.",
+		},
+		{
+			name:     "method of a deprecated service",
+			template: "method_doc",
+			data: &MethodContext{
+				Name: "Echo", FullName: "test.v1.TestService.Echo", Location: loc,
+				Deprecated: true, DeprecatedService: "test.v1.TestService",
+			},
+			expected: "// Echo implements the Echo RPC\n" +
+				"//\n" +
+				"// Proto: test.v1.TestService.Echo (test/v1/test_service.proto:12)\n" +
+				"//\n" +
+				"// Deprecated: service test.v1.TestService deprecated in test/v1/test_service.proto.",
+		},
+		{
+			name:     "service without comments",
+			template: "proto_doc",
+			data:     &ServiceContext{Name: "TestService", FullName: "test.v1.TestService", Location: loc},
+			expected: "\n//\n// Proto: test.v1.TestService (test/v1/test_service.proto:12)",
+		},
+		{
+			name:     "service with comments",
+			template: "proto_doc",
+			data: &ServiceContext{
+				Name: "TestService", FullName: "test.v1.TestService", Location: loc,
+				Comments: "TestService tests things\n\nAt length.",
+			},
+			expected: "\n//\n" +
+				"// TestService tests things.\n" +
+				"//\n" +
+				"// At length.\n" +
+				"//\n" +
+				"// Proto: test.v1.TestService (test/v1/test_service.proto:12)",
+		},
+		{
+			name:     "deprecated service",
+			template: "proto_doc",
+			data: &ServiceContext{
+				Name: "TestService", FullName: "test.v1.TestService", Location: loc,
+				Comments: "TestService tests things.", Deprecated: true,
+			},
+			expected: "\n//\n" +
+				"// TestService tests things.\n" +
+				"//\n" +
+				"// Proto: test.v1.TestService (test/v1/test_service.proto:12)\n" +
+				"//\n" +
+				"// Deprecated: service deprecated in test/v1/test_service.proto.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := executeTemplate(tt.template, Context{}, tt.data)
+			if err != nil {
+				t.Fatalf("executeTemplate() failed: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("%s =\n%s\nwant\n%s", tt.template, got, tt.expected)
+			}
+		})
+	}
+}
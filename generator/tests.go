@@ -0,0 +1,111 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// testFileName returns the test file scaffolded next to a method's stub
+func testFileName(serviceName, methodName string) string {
+	return strings.TrimSuffix(methodFileName(serviceName, methodName), ".go") + "_test.go"
+}
+
+// testFuncName returns the name of the test scaffolded for a method
+func testFuncName(structName, methodName string) string {
+	return "Test" + structName + "_" + methodName
+}
+
+// generateTestFiles scaffolds a table-driven test for every unary RPC whose
+// stub this run generated or refreshed, with tests=true, so the test can
+// expect the stub's CodeUnimplemented. Like method files, a test is never
+// written over an existing file, nor for a method some test file of the
+// package already tests.
+func generateTestFiles(svc *descriptorpb.ServiceDescriptorProto, ctx Context, idx *typeIndex, pkg *packageIndex, out *outputSet, opts *Options) error {
+	if !opts.Tests {
+		return nil
+	}
+
+	tested, err := testFuncs(constructFullPath(opts.Out, ctx.Dir))
+	if err != nil {
+		return fmt.Errorf("%s: %w", ctx.Dir, err)
+	}
+	if tested == nil {
+		return nil
+	}
+
+	for _, method := range svc.GetMethod() {
+		// Streams cannot be built outside a connect call, so only unary RPCs get a test
+		if method.GetClientStreaming() || method.GetServerStreaming() || ctx.skip[method.GetName()] {
+			continue
+		}
+		if loc, ok := pkg.lookup(ctx.StructName, method.GetName()); !ok || !loc.Generated {
+			continue
+		}
+		if tested[testFuncName(ctx.StructName, method.GetName())] {
+			continue
+		}
+		testPath := filepath.Join(ctx.Dir, testFileName(svc.GetName(), method.GetName()))
+		if out.exists(testPath) {
+			continue
+		}
+
+		// Each test file gets its own imports, starting from the service's
+		methodCtx := ctx
+		methodCtx.Imports = ctx.Imports.clone()
+		methodCtx.Imports.Add("testing", "")
+		methodCtx.Method = newMethodContext(method, idx, methodCtx.Imports)
+		methodCtx.MethodPath = testPath
+
+		content, err := renderGoFile(TEMPLATE_TEST, methodCtx)
+		if err != nil {
+			return fmt.Errorf("failed to render test template: %w", err)
+		}
+		out.write(testPath, content)
+	}
+	return nil
+}
+
+// testFuncs returns the names of the functions declared in the test files
+// of dir, or nil if one of them does not parse
+func testFuncs(dir string) (map[string]bool, error) {
+	funcs := make(map[string]bool)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return funcs, nil
+		}
+		return nil, fmt.Errorf("failed to read handler directory: %w", err)
+	}
+
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, entry.Name()), nil, parser.SkipObjectResolution)
+		if err != nil {
+			var list scanner.ErrorList
+			if !errors.As(err, &list) {
+				return nil, fmt.Errorf("failed to read existing test file: %w", err)
+			}
+			// Functions past the error are unknown, so a test may already exist
+			warnf("%v; not scaffolding tests until the file parses", list[0])
+			return nil, nil
+		}
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil {
+				funcs[fn.Name.Name] = true
+			}
+		}
+	}
+	return funcs, nil
+}
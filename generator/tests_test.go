@@ -0,0 +1,152 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestGenerateTestFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    string
+		existing  map[string]string // files in gen before the run
+		streaming bool              // make Ping server-streaming
+		expected  map[string][]string
+		missing   []string // test files that must not be written
+	}{
+		{
+			name:   "per method",
+			params: "out=gen,mode=per_method,tests=true",
+			expected: map[string][]string{
+				"test_service_echo_test.go": {
+					"\t\"context\"\n\t\"testing\"\n\n\t\"connectrpc.com/connect\"\n\ttestv1 \"example.com/gen/test/v1\"\n\t\"google.golang.org/protobuf/proto\"\n)",
+					"func TestTestServiceHandler_Echo(t *testing.T) {",
+					"\t\treq      *testv1.EchoRequest\n\t\twant     *testv1.EchoRequest\n",
+					"\t\t\treq:      &testv1.EchoRequest{},\n\t\t\twantCode: connect.CodeUnimplemented,\n",
+					"\t\t\th := NewTestServiceHandler()\n" +
+						"\t\t\tresp, err := h.Echo(context.Background(), connect.NewRequest(tt.req))\n",
+					"if connect.CodeOf(err) != tt.wantCode {",
+					"if !proto.Equal(resp.Msg, tt.want) {",
+				},
+				"test_service_ping_test.go": {"\t\twant     *commonv1.Status\n", "h.Ping(context.Background(), connect.NewRequest(tt.req))"},
+			},
+		},
+		{
+			name:     "per service",
+			params:   "out=gen,tests=true",
+			expected: map[string][]string{"test_service_echo_test.go": {"func TestTestServiceHandler_Echo(t *testing.T) {"}},
+		},
+		{
+			name:      "streaming RPC",
+			params:    "out=gen,tests=true",
+			streaming: true,
+			expected:  map[string][]string{"test_service_echo_test.go": {"func TestTestServiceHandler_Echo(t *testing.T) {"}},
+			missing:   []string{"test_service_ping_test.go"},
+		},
+		{
+			name:   "existing tests",
+			params: "out=gen,tests=true",
+			existing: map[string]string{
+				"test_service_echo_test.go": "package test_v1\n",
+				"handler_test.go":           "package test_v1\n\nimport \"testing\"\n\nfunc TestTestServiceHandler_Ping(t *testing.T) {}\n",
+			},
+			missing: []string{"test_service_echo_test.go", "test_service_ping_test.go"},
+		},
+		{
+			name:   "implemented method",
+			params: "out=gen,mode=per_method,tests=true",
+			existing: map[string]string{
+				"test_service_handler.go": "package test_v1\n\ntype TestServiceHandler struct{}\n",
+				"echo.go": "package test_v1\n\nimport (\n\t\"context\"\n\n\t\"connectrpc.com/connect\"\n\ttestv1 \"example.com/gen/test/v1\"\n)\n\n" +
+					"func (t *TestServiceHandler) Echo(ctx context.Context, req *connect.Request[testv1.EchoRequest]) (*connect.Response[testv1.EchoRequest], error) {\n" +
+					"\treturn connect.NewResponse(req.Msg), nil\n}\n",
+			},
+			expected: map[string][]string{"test_service_ping_test.go": {"func TestTestServiceHandler_Ping(t *testing.T) {"}},
+			missing:  []string{"test_service_echo_test.go"},
+		},
+		{
+			name:    "disabled",
+			params:  "out=gen",
+			missing: []string{"test_service_echo_test.go", "test_service_ping_test.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			if tt.existing != nil {
+				writeTestFiles(t, "gen", tt.existing)
+			}
+
+			req := testRequestWithServices(tt.params)
+			if tt.streaming {
+				req.GetProtoFile()[2].GetService()[0].GetMethod()[1].ServerStreaming = proto.Bool(true)
+			}
+			resp, err := Generate(req)
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			files := make(map[string]string)
+			for _, f := range resp.File {
+				files[f.GetName()] = f.GetContent()
+			}
+
+			for name, substrings := range tt.expected {
+				content, ok := files[name]
+				if !ok {
+					t.Errorf("%s not generated", name)
+					continue
+				}
+				for _, substr := range substrings {
+					if !strings.Contains(content, substr) {
+						t.Errorf("%s should contain %q\n%s", name, substr, content)
+					}
+				}
+			}
+			for _, name := range tt.missing {
+				if content, ok := files[name]; ok {
+					t.Errorf("%s should not be written\n%s", name, content)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateTestFilesKeepsServiceImports(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	req := testRequestWithServices("out=gen,tests=true")
+	opts, err := parseOptions(req.GetParameter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := newTypeIndex(req.GetProtoFile(), opts)
+	fileDesc := req.GetProtoFile()[2]
+	svc := fileDesc.GetService()[0]
+	ctx, err := buildContext(fileDesc, svc, idx, opts)
+	if err != nil {
+		t.Fatalf("buildContext() failed: %v", err)
+	}
+	pkg, err := loadPackageIndex("gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range svc.GetMethod() {
+		pkg.add(ctx.StructName, method.GetName(), methodLocation{File: "test_service_handler.go", RPC: true, Generated: true})
+	}
+
+	out := newOutputSet(opts.Out)
+	if err := generateTestFiles(svc, ctx, idx, pkg, out, opts); err != nil {
+		t.Fatalf("generateTestFiles() failed: %v", err)
+	}
+	if !out.exists("test_service_echo_test.go") {
+		t.Error("test_service_echo_test.go not generated")
+	}
+	for _, path := range []string{"testing", "google.golang.org/protobuf/proto"} {
+		if _, ok := ctx.Imports.byPath[path]; ok {
+			t.Errorf("generateTestFiles() added %q to the service's imports", path)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package generator
+
+import (
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// typeIndex locates the proto file declaring each message or enum in a request
+type typeIndex struct {
+	types      map[string]indexedType                       // fully-qualified type name -> declaration
+	packages   map[string]*descriptorpb.FileDescriptorProto // proto package -> first file declaring it
+	goPackages map[string]goPackage                         // proto file name -> Go package
+	warned     map[string]bool                              // proto files already reported without a Go package
+	decls      map[proto.Message]declaration                // descriptor -> where it is declared
+}
+
+// declaration locates a descriptor in the proto file declaring it
+type declaration struct {
+	file     *descriptorpb.FileDescriptorProto
+	path     []int32 // SourceCodeInfo path
+	fullName string  // e.g. "test.v1.TestService.Echo"
+}
+
+// goPackage is the Go package generated for a proto file
+type goPackage struct {
+	ImportPath string
+	Name       string
+}
+
+// indexedType is a message or enum declaration found in a proto file
+type indexedType struct {
+	file   *descriptorpb.FileDescriptorProto
+	goName string                        // Go identifier protoc-gen-go emits, e.g. "Outer_Inner"
+	msg    *descriptorpb.DescriptorProto // declaration of a message, nil for enums
+}
+
+// goType is a Go type reference resolved from a proto type
+type goType struct {
+	ImportPath  string // e.g. "example.com/gen/test/v1"
+	PackageName string // e.g. "testv1"
+	Name        string // e.g. "EchoRequest"
+}
+
+// String returns the package-qualified Go type, e.g. "testv1.EchoRequest"
+func (t goType) String() string {
+	if t.PackageName == "" {
+		return t.Name
+	}
+	return t.PackageName + "." + t.Name
+}
+
+// newTypeIndex indexes every message and enum declared in the given files,
+// along with the declaration of every descriptor in them
+func newTypeIndex(files []*descriptorpb.FileDescriptorProto, opts *Options) *typeIndex {
+	idx := &typeIndex{
+		types:      make(map[string]indexedType),
+		packages:   make(map[string]*descriptorpb.FileDescriptorProto),
+		goPackages: make(map[string]goPackage),
+		warned:     make(map[string]bool),
+		decls:      make(map[proto.Message]declaration),
+	}
+
+	for _, fd := range files {
+		pkg := fd.GetPackage()
+		if _, exists := idx.packages[pkg]; !exists {
+			idx.packages[pkg] = fd
+		}
+		idx.goPackages[fd.GetName()] = resolveGoPackage(fd, opts)
+
+		idx.addMessages(fd, "", []int32{fileMessageField}, fd.GetMessageType())
+		for i, enum := range fd.GetEnumType() {
+			idx.addType(fd, enum.GetName(), nil)
+			idx.addEnum(fd, enum.GetName(), []int32{fileEnumField, int32(i)}, enum)
+		}
+		for i, svc := range fd.GetService() {
+			name := qualifyProtoName(pkg, svc.GetName())
+			path := []int32{fileServiceField, int32(i)}
+			idx.decls[svc] = declaration{file: fd, path: path, fullName: name}
+			for j, method := range svc.GetMethod() {
+				idx.decls[method] = declaration{
+					file:     fd,
+					path:     append(slices.Clone(path), serviceMethodField, int32(j)),
+					fullName: name + "." + method.GetName(),
+				}
+			}
+		}
+	}
+
+	return idx
+}
+
+// addMessages indexes messages and, recursively, their nested messages and enums.
+// prefix is the dotted path of the enclosing messages, e.g. "Outer.", and
+// path the SourceCodeInfo path of the list holding msgs.
+func (idx *typeIndex) addMessages(fd *descriptorpb.FileDescriptorProto, prefix string, path []int32, msgs []*descriptorpb.DescriptorProto) {
+	for i, msg := range msgs {
+		name := prefix + msg.GetName()
+		msgPath := append(slices.Clone(path), int32(i))
+		idx.addType(fd, name, msg)
+		idx.decls[msg] = declaration{file: fd, path: msgPath, fullName: qualifyProtoName(fd.GetPackage(), name)}
+
+		for j, field := range msg.GetField() {
+			idx.decls[field] = declaration{
+				file:     fd,
+				path:     append(slices.Clone(msgPath), messageFieldField, int32(j)),
+				fullName: qualifyProtoName(fd.GetPackage(), name+"."+field.GetName()),
+			}
+		}
+		for j, oneof := range msg.GetOneofDecl() {
+			idx.decls[oneof] = declaration{
+				file:     fd,
+				path:     append(slices.Clone(msgPath), messageOneofField, int32(j)),
+				fullName: qualifyProtoName(fd.GetPackage(), name+"."+oneof.GetName()),
+			}
+		}
+
+		idx.addMessages(fd, name+".", append(slices.Clone(msgPath), messageNestedField), msg.GetNestedType())
+		for j, enum := range msg.GetEnumType() {
+			idx.addType(fd, name+"."+enum.GetName(), nil)
+			idx.addEnum(fd, name+"."+enum.GetName(), append(slices.Clone(msgPath), messageEnumField, int32(j)), enum)
+		}
+	}
+}
+
+// addEnum records the declarations of an enum and its values
+func (idx *typeIndex) addEnum(fd *descriptorpb.FileDescriptorProto, relName string, path []int32, enum *descriptorpb.EnumDescriptorProto) {
+	idx.decls[enum] = declaration{file: fd, path: path, fullName: qualifyProtoName(fd.GetPackage(), relName)}
+	for i, value := range enum.GetValue() {
+		idx.decls[value] = declaration{
+			file: fd,
+			path: append(slices.Clone(path), enumValueField, int32(i)),
+			// Enum values are scoped to the enclosing message or package
+			fullName: qualifyProtoName(fd.GetPackage(), strings.TrimSuffix(relName, enum.GetName())+value.GetName()),
+		}
+	}
+}
+
+// addType indexes a type by its name relative to the file's proto package
+func (idx *typeIndex) addType(fd *descriptorpb.FileDescriptorProto, relName string, msg *descriptorpb.DescriptorProto) {
+	idx.types[qualifyProtoName(fd.GetPackage(), relName)] = indexedType{
+		file:   fd,
+		goName: goCamelCase(relName),
+		msg:    msg,
+	}
+}
+
+// lookup returns the declaration of the given fully-qualified proto type
+func (idx *typeIndex) lookup(protoType string) (indexedType, bool) {
+	protoType = strings.TrimPrefix(protoType, ".")
+	if t, ok := idx.types[protoType]; ok {
+		return t, true
+	}
+
+	// The request may not carry the declaring file (e.g. hand-built requests);
+	// fall back to the longest known proto package prefixing the type.
+	for pkg := protoType; ; {
+		dot := strings.LastIndex(pkg, ".")
+		if dot == -1 {
+			return indexedType{}, false
+		}
+		pkg = pkg[:dot]
+		if fd, ok := idx.packages[pkg]; ok {
+			return indexedType{
+				file:   fd,
+				goName: goCamelCase(protoType[len(pkg)+1:]),
+			}, true
+		}
+	}
+}
+
+// goPackageOf returns the Go package generated for a proto file, warning once
+// when its import path cannot be determined
+func (idx *typeIndex) goPackageOf(fd *descriptorpb.FileDescriptorProto) goPackage {
+	pkg, ok := idx.goPackages[fd.GetName()]
+	if !ok {
+		pkg = resolveGoPackage(fd, nil)
+	}
+
+	if pkg.ImportPath == "" && !idx.warned[fd.GetName()] {
+		idx.warned[fd.GetName()] = true
+		warnf("unable to determine Go import path for %q; set go_package or pass M%s=<import path>",
+			fd.GetName(), fd.GetName())
+	}
+	return pkg
+}
+
+// resolveGoPackage determines the Go package of a proto file the way
+// protoc-gen-go does: an M<file> option takes precedence over go_package,
+// and the package name defaults to the last element of the import path
+func resolveGoPackage(fd *descriptorpb.FileDescriptorProto, opts *Options) goPackage {
+	var pkg goPackage
+	if opts != nil {
+		if mapped, ok := opts.ImportPaths[fd.GetName()]; ok {
+			pkg.ImportPath = extractGoPackageImport(mapped)
+			if strings.Contains(mapped, ";") {
+				pkg.Name = extractGoPackageName(mapped)
+			}
+		}
+	}
+
+	goPackageOption := fd.GetOptions().GetGoPackage()
+	if pkg.ImportPath == "" {
+		pkg.ImportPath = extractGoPackageImport(goPackageOption)
+	}
+	if pkg.Name == "" && strings.Contains(goPackageOption, ";") {
+		pkg.Name = extractGoPackageName(goPackageOption)
+	}
+	if pkg.Name == "" && pkg.ImportPath != "" {
+		pkg.Name = extractGoPackageName(pkg.ImportPath)
+	}
+
+	return pkg
+}
+
+// qualifyProtoName joins a proto package and a type name
+func qualifyProtoName(pkg, name string) string {
+	if pkg == "" {
+		return name
+	}
+	return pkg + "." + name
+}
+
+// goCamelCase converts a proto name relative to its package into the Go
+// identifier protoc-gen-go generates for it. Nested names are joined with
+// underscores ("Outer.Inner" -> "Outer_Inner"), words separated by
+// underscores are capitalized ("foo_bar" -> "FooBar"), and a leading
+// underscore becomes "X" ("_foo" -> "XFoo").
+func goCamelCase(s string) string {
+	// Invariant: if the next letter is lower case, it must be converted
+	// to upper case. Words are marked by _ or an upper case letter, and
+	// digits are treated as words.
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '.' && i+1 < len(s) && isASCIILower(s[i+1]):
+			// Skip over '.' in ".{{lowercase}}"
+		case c == '.':
+			b = append(b, '_')
+		case c == '_' && (i == 0 || s[i-1] == '.'):
+			// Convert initial '_' (and '_' after '.') to 'X' so the
+			// identifier starts with a capital letter
+			b = append(b, 'X')
+		case c == '_' && i+1 < len(s) && isASCIILower(s[i+1]):
+			// Skip over '_' in "_{{lowercase}}"
+		case isASCIIDigit(c):
+			b = append(b, c)
+		default:
+			// Assume a letter: the word it starts must begin upper case
+			if isASCIILower(c) {
+				c -= 'a' - 'A'
+			}
+			b = append(b, c)
+
+			// Accept the lower case sequence that follows
+			for ; i+1 < len(s) && isASCIILower(s[i+1]); i++ {
+				b = append(b, s[i+1])
+			}
+		}
+	}
+	return string(b)
+}
+
+func isASCIILower(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
+
+func isASCIIDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
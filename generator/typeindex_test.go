@@ -0,0 +1,177 @@
+package generator
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func testProtoFiles() []*descriptorpb.FileDescriptorProto {
+	return []*descriptorpb.FileDescriptorProto{
+		{
+			Name:    proto.String("google/protobuf/empty.proto"),
+			Package: proto.String("google.protobuf"),
+			Options: &descriptorpb.FileOptions{
+				GoPackage: proto.String("google.golang.org/protobuf/types/known/emptypb"),
+			},
+			MessageType: []*descriptorpb.DescriptorProto{
+				{Name: proto.String("Empty")},
+			},
+		},
+		{
+			Name:    proto.String("common/v1/status.proto"),
+			Package: proto.String("common.v1"),
+			Options: &descriptorpb.FileOptions{
+				GoPackage: proto.String("example.com/gen/common/v1;commonv1"),
+			},
+			MessageType: []*descriptorpb.DescriptorProto{
+				{Name: proto.String("Status")},
+			},
+		},
+		{
+			Name:    proto.String("test/v1/test_service.proto"),
+			Package: proto.String("test.v1"),
+			Options: &descriptorpb.FileOptions{
+				GoPackage: proto.String("example.com/gen/test/v1;testv1"),
+			},
+			MessageType: []*descriptorpb.DescriptorProto{
+				{Name: proto.String("EchoRequest")},
+				{
+					Name: proto.String("Outer"),
+					NestedType: []*descriptorpb.DescriptorProto{
+						{
+							Name: proto.String("Inner"),
+							NestedType: []*descriptorpb.DescriptorProto{
+								{Name: proto.String("deep_message")},
+							},
+						},
+					},
+					EnumType: []*descriptorpb.EnumDescriptorProto{
+						{Name: proto.String("Kind")},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestResolveGoType(t *testing.T) {
+	idx := newTypeIndex(testProtoFiles(), &Options{})
+
+	tests := []struct {
+		protoType string
+		expected  goType
+	}{
+		{
+			".google.protobuf.Empty",
+			goType{ImportPath: "google.golang.org/protobuf/types/known/emptypb", PackageName: "emptypb", Name: "Empty"},
+		},
+		{
+			".common.v1.Status",
+			goType{ImportPath: "example.com/gen/common/v1", PackageName: "commonv1", Name: "Status"},
+		},
+		{
+			".test.v1.EchoRequest",
+			goType{ImportPath: "example.com/gen/test/v1", PackageName: "testv1", Name: "EchoRequest"},
+		},
+		{
+			// Not declared in the request, but its package is known
+			".test.v1.EchoResponse",
+			goType{ImportPath: "example.com/gen/test/v1", PackageName: "testv1", Name: "EchoResponse"},
+		},
+		{
+			".test.v1.Outer.Inner",
+			goType{ImportPath: "example.com/gen/test/v1", PackageName: "testv1", Name: "Outer_Inner"},
+		},
+		{
+			".test.v1.Outer.Inner.deep_message",
+			goType{ImportPath: "example.com/gen/test/v1", PackageName: "testv1", Name: "Outer_InnerDeepMessage"},
+		},
+		{
+			".test.v1.Outer.Kind",
+			goType{ImportPath: "example.com/gen/test/v1", PackageName: "testv1", Name: "Outer_Kind"},
+		},
+		{
+			// Not declared in the request: the longest known package is stripped
+			".test.v1.Missing.Nested",
+			goType{ImportPath: "example.com/gen/test/v1", PackageName: "testv1", Name: "Missing_Nested"},
+		},
+		{
+			".unknown.v1.Thing",
+			goType{PackageName: "unknown_v1", Name: "Thing"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.protoType, func(t *testing.T) {
+			result := resolveGoType(tt.protoType, idx)
+			if result != tt.expected {
+				t.Errorf("resolveGoType(%v) = %+v, want %+v", tt.protoType, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGoCamelCase(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"EchoRequest", "EchoRequest"},
+		{"echo_request", "EchoRequest"},
+		{"Outer.Inner", "Outer_Inner"},
+		{"Outer.inner", "OuterInner"},
+		{"Outer_Inner", "Outer_Inner"},
+		{"_private", "XPrivate"},
+		{"Outer._inner", "Outer_XInner"},
+		{"v2_request", "V2Request"},
+		{"HTTPRequest", "HTTPRequest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result := goCamelCase(tt.input)
+			if result != tt.expected {
+				t.Errorf("goCamelCase(%v) = %v, want %v", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResolveGoPackage(t *testing.T) {
+	opts := &Options{
+		ImportPaths: map[string]string{
+			"mapped.proto":          "example.com/gen/mapped;mappedpb",
+			"mapped_path.proto":     "example.com/gen/mapped/v1",
+			"overridden.proto":      "example.com/gen/override",
+			"overridden_name.proto": "example.com/gen/override",
+		},
+	}
+
+	tests := []struct {
+		file      string
+		goPackage string
+		expected  goPackage
+	}{
+		{"mapped.proto", "", goPackage{ImportPath: "example.com/gen/mapped", Name: "mappedpb"}},
+		{"mapped_path.proto", "", goPackage{ImportPath: "example.com/gen/mapped/v1", Name: "v1"}},
+		{"overridden.proto", "example.com/gen/original", goPackage{ImportPath: "example.com/gen/override", Name: "override"}},
+		{"overridden_name.proto", "example.com/gen/original;originalpb", goPackage{ImportPath: "example.com/gen/override", Name: "originalpb"}},
+		{"plain.proto", "example.com/gen/plain-api", goPackage{ImportPath: "example.com/gen/plain-api", Name: "plain_api"}},
+		{"missing.proto", "", goPackage{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			fd := &descriptorpb.FileDescriptorProto{
+				Name:    proto.String(tt.file),
+				Options: &descriptorpb.FileOptions{GoPackage: proto.String(tt.goPackage)},
+			}
+			result := resolveGoPackage(fd, opts)
+			if result != tt.expected {
+				t.Errorf("resolveGoPackage(%v) = %+v, want %+v", tt.file, result, tt.expected)
+			}
+		})
+	}
+}